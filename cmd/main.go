@@ -1,27 +1,41 @@
 package main
 
 import (
+	"cs425_mp1/internal/cache"
 	"cs425_mp1/internal/distributed_engine"
 	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/index"
+	"cs425_mp1/internal/logformat"
+	"cs425_mp1/internal/network"
 	"cs425_mp1/internal/utils"
 	"encoding/gob"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 )
 
 const (
 	MACHINE_NAME_FORMAT = "fa23-cs425-19%02d.cs.illinois.edu"
 	PORT_FORMAT         = "80%02d" // 8001, 8002, ... 8010 - based on the
+	GRPC_PORT_FORMAT    = "90%02d" // 9001, 9002, ... 9010 - ports of the gRPC service, with -grpc
 	OUTPUT_JSON_FORMAT  = "test%d.json"
+	BYTES_PER_MB        = 1 << 20
 )
 
 var flagNumMachines *int
 var localLogFile *string // full path of the local log file of this machine
 var cacheSize *int
+var cacheMaxMB *int
+var cacheMaxEntryMB *int
+var cacheTTL *time.Duration
+var cacheDir *string
+var cacheDiskMB *int
 var verbose *bool
 
 var peerServerAddresses []string
@@ -29,13 +43,41 @@ var engine *distributed_engine.DistributedGrepEngine
 var serverPort string
 
 var testDir *string
+var migrateJsonDir *string
+var logFormat *string
+var mergedView *bool
+var useIndex *bool
+var indexBlockKB *int
+var workers *int
+var chunkMB *int
+var compressKB *int
+var useGrpc *bool
+var queryTimeout *time.Duration
+var httpAddr *string
+var executor *grep.Executor
 
 func ParseArguments() {
 	flagNumMachines = flag.Int("n", 10, "Number of Machines in the network in the range [2, 10]")
 	localLogFile = flag.String("f", "", "Filename of the log file")
 	cacheSize = flag.Int("c", 10, "Size of the in-memory LRU cache")
+	cacheMaxMB = flag.Int("cache-mb", 512, "Max total size in MB of the outputs stored in the in-memory LRU cache")
+	cacheMaxEntryMB = flag.Int("cache-entry-mb", 64, "Outputs larger than this size in MB are not stored in the cache (0 = no limit)")
+	cacheTTL = flag.Duration("cache-ttl", 0, "Time after which a cached output is discarded, ex: 10m (0 = never)")
+	cacheDir = flag.String("cache-dir", "", "Directory to persist cached outputs to so they survive restarts (\"\" = memory only)")
+	cacheDiskMB = flag.Int("cache-disk-mb", 2048, "Max total size in MB of the outputs persisted to the cache directory")
 	verbose = flag.Bool("v", false, "Indicates if you want messages to be printed out")
 	testDir = flag.String("t", "", "If you wish to run this program in TEST mode, put the directory you want your output JSON files to be stored")
+	logFormat = flag.String("log-format", "default", "Format of the lines in the log file for --where filters: default, json, logfmt or regex:<pattern with named groups>")
+	mergedView = flag.Bool("merge", false, "Print the output lines of all machines merged in time order instead of one machine at a time")
+	useIndex = flag.Bool("index", false, "Keep a trigram index of the local log file to only grep the parts of it that may match literal patterns")
+	indexBlockKB = flag.Int("index-block-kb", index.DEFAULT_BLOCK_SIZE/1024, "Size in KB of the blocks of the log file the index keeps track of")
+	workers = flag.Int("workers", runtime.NumCPU(), "Number of chunks of the local log file scanned in parallel by --expr queries (1 = scan the file in one go)")
+	chunkMB = flag.Int("chunk-mb", grep.DEFAULT_CHUNK_SIZE/BYTES_PER_MB, "Size in MB of the chunks of the local log file scanned in parallel")
+	compressKB = flag.Int("compress-kb", network.DEFAULT_COMPRESSION_THRESHOLD/1024, "Outputs of at least this size in KB are gzipped when sent to peers (-1 = never compress)")
+	useGrpc = flag.Bool("grpc", false, "Also serve the gRPC service (see docs/protocol.md) and send queries to peers over gRPC instead of the raw TCP protocol")
+	queryTimeout = flag.Duration("query-timeout", time.Minute, "Deadline of the queries sent to peers over gRPC, ex: 30s (0 = no deadline)")
+	httpAddr = flag.String("http", "", "Address to serve the HTTP/JSON query API on, ex: :8080 for localhost only or 0.0.0.0:8080 for all interfaces (\"\" = no HTTP API)")
+	migrateJsonDir = flag.String("migrate-json", "", "Rewrite the queries of the test JSON files in this directory to the current format and exit")
 	flag.Parse()
 }
 
@@ -45,12 +87,43 @@ func Init() {
 
 	peerServerAddresses = utils.GetPeerServerAddresses(MACHINE_NAME_FORMAT, PORT_FORMAT, *flagNumMachines)
 	serverPort = utils.GetLocalhostPort(MACHINE_NAME_FORMAT, PORT_FORMAT, *flagNumMachines)
+	cacheConfig := cache.Config{
+		MaxEntries:    *cacheSize,
+		MaxBytes:      int64(*cacheMaxMB) * BYTES_PER_MB,
+		MaxEntryBytes: int64(*cacheMaxEntryMB) * BYTES_PER_MB,
+		TTL:           *cacheTTL,
+		DiskDir:       *cacheDir,
+		DiskMaxBytes:  int64(*cacheDiskMB) * BYTES_PER_MB,
+	}
 	if *testDir != "" {
 		_, _ = fmt.Fprintf(os.Stderr, "Opening in [TEST] mode. Saving test output JSON files to %s\n", *testDir)
 		dirPlusFile := filepath.Join(*testDir, OUTPUT_JSON_FORMAT)
-		engine = distributed_engine.CreateEngine(*localLogFile, serverPort, peerServerAddresses, *cacheSize, *verbose, dirPlusFile)
+		engine = distributed_engine.CreateEngine(*localLogFile, serverPort, peerServerAddresses, cacheConfig, *verbose, dirPlusFile)
+	} else {
+		engine = distributed_engine.CreateEngine(*localLogFile, serverPort, peerServerAddresses, cacheConfig, *verbose, "")
+	}
+
+	logParser, err := logformat.NewParser(*logFormat)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	executor = grep.NewExecutor(logParser)
+	executor.SetParallelism(*workers, int64(*chunkMB)*BYTES_PER_MB)
+	if *useIndex {
+		idx := index.New(*localLogFile, *indexBlockKB*1024)
+		go func() { // index the file in the background, queries grep the parts not indexed yet in the meantime
+			if err := idx.Update(); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "Failed to index %s: %v\n", *localLogFile, err)
+			}
+		}()
+		executor.SetIndex(idx)
+	}
+	engine.SetExecutor(executor)
+	engine.SetMergedView(*mergedView)
+	if *compressKB < 0 {
+		engine.SetCompressionThreshold(-1)
 	} else {
-		engine = distributed_engine.CreateEngine(*localLogFile, serverPort, peerServerAddresses, *cacheSize, *verbose, "")
+		engine.SetCompressionThreshold(*compressKB * 1024)
 	}
 }
 
@@ -69,12 +142,44 @@ func ProcessInput() (string, error) {
 func SetupEngine() {
 	_, _ = fmt.Fprintln(os.Stderr, "Setting up server. Listening to new connections...")
 	engine.InitializeServer()
-	engine.ConnectToPeers()
-	_, _ = fmt.Fprintln(os.Stderr, "Connected to all machines")
+	if *useGrpc { // peers are dialed lazily, so don't wait for them to be up
+		grpcPort := utils.GetLocalhostPort(MACHINE_NAME_FORMAT, GRPC_PORT_FORMAT, *flagNumMachines)
+		engine.InitializeGrpcServer(grpcPort)
+		engine.ConnectToGrpcPeers(utils.GetPeerServerAddresses(MACHINE_NAME_FORMAT, GRPC_PORT_FORMAT, *flagNumMachines))
+		engine.SetQueryTimeout(*queryTimeout)
+		_, _ = fmt.Fprintf(os.Stderr, "Serving gRPC on %s. Sending queries to peers over gRPC\n", grpcPort)
+	} else {
+		engine.ConnectToPeers()
+		_, _ = fmt.Fprintln(os.Stderr, "Connected to all machines")
+	}
+	if *httpAddr != "" { // once the peers are set up, since requests are sent to them
+		engine.InitializeHttpServer(*httpAddr)
+		_, _ = fmt.Fprintf(os.Stderr, "Serving the HTTP API on %s\n", *httpAddr)
+	}
+}
+
+// Rewrites the test JSON files in the directory with their queries packaged in the current format
+func MigrateJsonFiles(dir string) {
+	jsonFiles, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Fatalf("Invalid directory %s: %v", dir, err)
+	}
+	for _, jsonFile := range jsonFiles {
+		changed, err := distributed_engine.MigrateJsonFile(jsonFile)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to migrate %s: %v\n", jsonFile, err)
+		} else if changed {
+			fmt.Printf("Migrated %s\n", jsonFile)
+		}
+	}
 }
 
 func main() {
 	ParseArguments()
+	if *migrateJsonDir != "" {
+		MigrateJsonFiles(*migrateJsonDir)
+		return
+	}
 	Init()
 	SetupEngine()
 
@@ -84,6 +189,30 @@ func main() {
 			//engine.Shutdown()
 			break
 		}
+		if inputStr == "stats" { // print this machine's cache stats instead of running a query
+			fmt.Print(engine.CacheStats().ToString())
+			if diskStats, ok := engine.DiskCacheStats(); ok {
+				fmt.Printf("Disk Cache:\n%s", diskStats.ToString())
+			}
+			fmt.Printf("Network:\n%s", engine.NetworkStats().ToString())
+			if indexStats, ok := executor.IndexStats(); ok {
+				fmt.Printf("Index:\nIndexed MB: %.1f\nBlocks: %d\nTrigrams: %d\n",
+					float64(indexStats.IndexedBytes)/BYTES_PER_MB, indexStats.Blocks, indexStats.Trigrams)
+			}
+			continue
+		}
+		if inputStr == "merge on" || inputStr == "merge off" { // toggle the time ordered merged view of the outputs
+			engine.SetMergedView(inputStr == "merge on")
+			continue
+		}
+		if inputStr == "next" { // fetch the next page of the last query with --max-results
+			if nextPage, ok := engine.NextPage(); ok {
+				engine.Execute(nextPage)
+			} else {
+				fmt.Println("No More Results")
+			}
+			continue
+		}
 		grepQuery, err := grep.CreateGrepQueryFromInput(inputStr)
 		if err != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
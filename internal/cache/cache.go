@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Config defines the limits of a Cache. A limit of 0 means that limit is not enforced
+type Config struct {
+	MaxEntries    int           // max number of entries in the cache
+	MaxBytes      int64         // max total size in bytes of all the entries in the cache
+	MaxEntryBytes int64         // entries larger than this are not admitted into the cache
+	TTL           time.Duration // entries older than this are treated as missing and removed
+	DiskDir       string        // directory of the persistent DiskCache backing the cache. "" = no disk cache
+	DiskMaxBytes  int64         // max total size in bytes of the files in DiskDir
+}
+
+// Stats holds counters describing how the cache has been used since it was created
+type Stats struct {
+	Hits        uint64 // number of Get() calls that found an entry
+	Misses      uint64 // number of Get() calls that did not find an entry (including expired ones)
+	Evictions   uint64 // number of entries removed to make room for new entries
+	Expirations uint64 // number of entries removed because they outlived the TTL
+	Rejections  uint64 // number of entries not admitted because they were larger than MaxEntryBytes
+	Entries     int    // current number of entries
+	Bytes       int64  // current total size of all entries
+}
+
+// Cache is a thread-safe LRU cache bounded by both number of entries and total size in bytes.
+// Each entry is added with its size since the cache cannot know how large an arbitrary value is
+type Cache struct {
+	mu       sync.Mutex
+	config   Config
+	lruList  *list.List               // front = most recently used
+	items    map[string]*list.Element // key -> element in lruList holding an *entry
+	curBytes int64
+	stats    Stats
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	size    int64
+	addedAt time.Time
+}
+
+// New creates a Cache with the limits given in config.
+// Returns an error if the config has a negative limit or no limit on both the number of entries and bytes
+func New(config Config) (*Cache, error) {
+	if config.MaxEntries < 0 || config.MaxBytes < 0 || config.MaxEntryBytes < 0 || config.TTL < 0 {
+		return nil, errors.New("Invalid cache config - limits must not be negative")
+	}
+	if config.MaxEntries == 0 && config.MaxBytes == 0 {
+		return nil, errors.New("Invalid cache config - must limit the number of entries or bytes")
+	}
+
+	c := &Cache{
+		config:  config,
+		lruList: list.New(),
+		items:   make(map[string]*list.Element),
+	}
+	return c, nil
+}
+
+// Get returns the value stored for key and marks it as the most recently used.
+// Returns false if the key is not in the cache or its entry has expired
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if c.isExpired(e) {
+		c.removeElement(elem)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.lruList.MoveToFront(elem)
+	c.stats.Hits++
+	return e.value, true
+}
+
+// Add stores value for key with the given size in bytes, replacing any existing value for key,
+// and evicts the least recently used entries until the cache is within its limits.
+// Returns false if the value was not admitted because it is too large, in which case any
+// existing value for key is removed as well since it is now stale
+func (c *Cache) Add(key string, value interface{}, size int64) bool {
+	return c.AddAt(key, value, size, time.Now())
+}
+
+// AddAt is like Add, but for a value that was created at addedAt instead of now (ex: loaded from a DiskCache),
+// so that it expires once it outlives the TTL since addedAt
+func (c *Cache) AddAt(key string, value interface{}, size int64, addedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+
+	if (c.config.MaxEntryBytes > 0 && size > c.config.MaxEntryBytes) || (c.config.MaxBytes > 0 && size > c.config.MaxBytes) {
+		c.stats.Rejections++
+		return false
+	}
+
+	elem := c.lruList.PushFront(&entry{key: key, value: value, size: size, addedAt: addedAt})
+	c.items[key] = elem
+	c.curBytes += size
+
+	for c.isOverLimit() {
+		c.removeElement(c.lruList.Back())
+		c.stats.Evictions++
+	}
+
+	return true
+}
+
+// Remove deletes the entry for key if it is in the cache
+func (c *Cache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// Stats returns a snapshot of the cache's counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.lruList.Len()
+	stats.Bytes = c.curBytes
+	return stats
+}
+
+// Formats the stats as a string
+func (s Stats) ToString() string {
+	strFormat := "Cache Entries: %d\nCache Bytes: %d\nHits: %d\nMisses: %d\nEvictions: %d\nExpirations: %d\nRejections: %d\n"
+	return fmt.Sprintf(strFormat, s.Entries, s.Bytes, s.Hits, s.Misses, s.Evictions, s.Expirations, s.Rejections)
+}
+
+// Helper function - caller must hold c.mu
+func (c *Cache) isOverLimit() bool {
+	return (c.config.MaxEntries > 0 && c.lruList.Len() > c.config.MaxEntries) ||
+		(c.config.MaxBytes > 0 && c.curBytes > c.config.MaxBytes)
+}
+
+// Helper function - caller must hold c.mu
+func (c *Cache) isExpired(e *entry) bool {
+	return c.config.TTL > 0 && time.Since(e.addedAt) > c.config.TTL
+}
+
+// Helper function - caller must hold c.mu
+func (c *Cache) removeElement(elem *list.Element) {
+	e := c.lruList.Remove(elem).(*entry)
+	delete(c.items, e.key)
+	c.curBytes -= e.size
+}
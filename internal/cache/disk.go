@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DISK_FILE_EXTENSION = ".cache"
+	DISK_TEMP_EXTENSION = ".tmp"
+	DISK_MAGIC          = "DLQC" // first bytes of every cache file, followed by the format version
+	DISK_VERSION        = 2      // files of other versions are treated as corrupted (v1 files had no added time)
+)
+
+// Size of the header of a cache file
+// Format: [magic][version][crc32 of the rest of the file][added at][key size][key][data]
+//
+//	[magic] is DISK_MAGIC, [version] is 1 byte, [crc32] and [key size] are 4 byte big-endian numbers,
+//	[added at] is the time the value was stored in unix nanoseconds, as an 8 byte big-endian number
+const DISK_HEADER_SIZE = len(DISK_MAGIC) + 1 + 4 + 8 + 4
+
+// DiskCache is a thread-safe cache that stores each value in its own file under a directory,
+// so that its entries survive restarts of the program. The index of the files in the directory is
+// loaded at creation, and when the total size of the files exceeds the limit the least recently used
+// files are deleted. Files that are corrupted (ex: partially written before a crash) or older than the
+// TTL are treated as missing and deleted instead of failing the read
+type DiskCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64                    // 0 means no limit
+	ttl      time.Duration            // values stored longer ago than this are expired. 0 means no limit
+	files    map[string]*diskFileInfo // file name -> info
+	curBytes int64
+	stats    Stats
+}
+
+type diskFileInfo struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// NewDiskCache creates a DiskCache under dir (creating the directory if it does not exist) and loads
+// the index of the cache files already in it, deleting leftover temporary files. Values are kept for
+// ttl after they are stored, like the entries of a Cache (0 = no limit)
+func NewDiskCache(dir string, maxBytes int64, ttl time.Duration) (*DiskCache, error) {
+	if maxBytes < 0 || ttl < 0 {
+		return nil, errors.New("Invalid disk cache config - limits must not be negative")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &DiskCache{dir: dir, maxBytes: maxBytes, ttl: ttl, files: make(map[string]*diskFileInfo)}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasSuffix(name, DISK_TEMP_EXTENSION) { // left over from a write that never finished
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, DISK_FILE_EXTENSION) || !dirEntry.Type().IsRegular() {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		d.files[name] = &diskFileInfo{size: info.Size(), lastUsed: info.ModTime()}
+		d.curBytes += info.Size()
+	}
+
+	d.mu.Lock()
+	d.evictOverLimit()
+	d.mu.Unlock()
+
+	return d, nil
+}
+
+// Get returns the data stored for key along with the time it was stored. Returns false if there is no
+// file for key, or if the file could not be read, is corrupted or has expired, in which case the file is deleted
+func (d *DiskCache) Get(key string) ([]byte, time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := diskFileName(key)
+	info, ok := d.files[name]
+	if !ok {
+		d.stats.Misses++
+		return nil, time.Time{}, false
+	}
+
+	path := filepath.Join(d.dir, name)
+	fileData, err := os.ReadFile(path)
+	var data []byte
+	var addedAt time.Time
+	if err == nil {
+		data, addedAt, err = decodeDiskFile(fileData, key)
+	}
+	if err != nil {
+		d.removeFile(name)
+		d.stats.Misses++
+		return nil, time.Time{}, false
+	}
+	if d.ttl > 0 && time.Since(addedAt) > d.ttl {
+		d.removeFile(name)
+		d.stats.Expirations++
+		d.stats.Misses++
+		return nil, time.Time{}, false
+	}
+
+	info.lastUsed = time.Now()
+	_ = os.Chtimes(path, info.lastUsed, info.lastUsed) // so the LRU order survives restarts
+	d.stats.Hits++
+	return data, addedAt, true
+}
+
+// Put stores data for key, replacing any existing file for key, and deletes the least recently
+// used files until the cache is within its size limit. The file is written to a temporary file
+// first and then renamed, so a crash while writing never leaves a partially written cache file
+func (d *DiskCache) Put(key string, data []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := diskFileName(key)
+	d.removeFile(name)
+
+	fileData := encodeDiskFile(key, data, time.Now())
+	size := int64(len(fileData))
+	if d.maxBytes > 0 && size > d.maxBytes {
+		d.stats.Rejections++
+		return nil
+	}
+
+	path := filepath.Join(d.dir, name)
+	tempPath := path + DISK_TEMP_EXTENSION
+	if err := os.WriteFile(tempPath, fileData, 0644); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	d.files[name] = &diskFileInfo{size: size, lastUsed: time.Now()}
+	d.curBytes += size
+	d.evictOverLimit()
+	return nil
+}
+
+// Stats returns a snapshot of the disk cache's counters
+func (d *DiskCache) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	stats.Entries = len(d.files)
+	stats.Bytes = d.curBytes
+	return stats
+}
+
+// Helper function - caller must hold d.mu
+func (d *DiskCache) evictOverLimit() {
+	if d.maxBytes == 0 || d.curBytes <= d.maxBytes {
+		return
+	}
+
+	names := make([]string, 0, len(d.files))
+	for name := range d.files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return d.files[names[i]].lastUsed.Before(d.files[names[j]].lastUsed)
+	})
+
+	for _, name := range names {
+		if d.curBytes <= d.maxBytes {
+			break
+		}
+		d.removeFile(name)
+		d.stats.Evictions++
+	}
+}
+
+// Helper function - caller must hold d.mu
+func (d *DiskCache) removeFile(name string) {
+	info, ok := d.files[name]
+	if !ok {
+		return
+	}
+	_ = os.Remove(filepath.Join(d.dir, name))
+	delete(d.files, name)
+	d.curBytes -= info.size
+}
+
+// Returns the name of the file that stores the value for key.
+// Keys are hashed since they can contain characters that are not allowed in file names
+func diskFileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + DISK_FILE_EXTENSION
+}
+
+// Helper function to build the contents of a cache file. See DISK_HEADER_SIZE for the format
+func encodeDiskFile(key string, data []byte, addedAt time.Time) []byte {
+	checksumStart := len(DISK_MAGIC) + 1 + 4
+	fileData := make([]byte, checksumStart, DISK_HEADER_SIZE+len(key)+len(data))
+	copy(fileData, DISK_MAGIC)
+	fileData[len(DISK_MAGIC)] = DISK_VERSION
+	fileData = binary.BigEndian.AppendUint64(fileData, uint64(addedAt.UnixNano()))
+	fileData = binary.BigEndian.AppendUint32(fileData, uint32(len(key)))
+	fileData = append(fileData, key...)
+	fileData = append(fileData, data...)
+
+	binary.BigEndian.PutUint32(fileData[len(DISK_MAGIC)+1:], crc32.ChecksumIEEE(fileData[checksumStart:]))
+	return fileData
+}
+
+// Helper function to validate the contents of a cache file and extract the data stored in it and the time it
+// was stored. Returns an error if the file is corrupted or stores the value of a different key
+func decodeDiskFile(fileData []byte, key string) ([]byte, time.Time, error) {
+	if len(fileData) < DISK_HEADER_SIZE || string(fileData[:len(DISK_MAGIC)]) != DISK_MAGIC {
+		return nil, time.Time{}, errors.New("Corrupted cache file - invalid header")
+	}
+	if fileData[len(DISK_MAGIC)] != DISK_VERSION {
+		return nil, time.Time{}, errors.New("Unsupported cache file version")
+	}
+
+	header := fileData[len(DISK_MAGIC)+1 : DISK_HEADER_SIZE]
+	checksum := binary.BigEndian.Uint32(header[:4])
+	addedAt := time.Unix(0, int64(binary.BigEndian.Uint64(header[4:12])))
+	keySize := int(binary.BigEndian.Uint32(header[12:]))
+
+	body := fileData[DISK_HEADER_SIZE:]
+	if keySize > len(body) || crc32.ChecksumIEEE(fileData[len(DISK_MAGIC)+1+4:]) != checksum {
+		return nil, time.Time{}, errors.New("Corrupted cache file - checksum mismatch")
+	}
+	if string(body[:keySize]) != key {
+		return nil, time.Time{}, errors.New("Cache file belongs to a different key")
+	}
+
+	return body[keySize:], addedAt, nil
+}
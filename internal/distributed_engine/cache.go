@@ -0,0 +1,176 @@
+package distributed_engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"cs425_mp1/internal/grep"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Approximate number of bytes a cache entry takes up besides its output and boundary
+const CACHE_ENTRY_OVERHEAD_BYTES = 256
+
+// Value stored in the LRU cache for a grep query.
+// Since log files are append-only, a cached output stays valid for the first `offset` bytes of the
+// log file, and only the bytes appended after that have to be grepped to bring it up to date
+type cacheEntry struct {
+	output   *grep.GrepOutput
+	offset   int64       // number of bytes of the log file that output covers (always ends on a line boundary)
+	fileInfo os.FileInfo // info of the log file when the entry was created, used to detect rotation
+	boundary []byte      // last bytes before offset, used to detect a truncated file that grew back past offset
+}
+
+// Form of a cacheEntry stored in the disk cache. The file info is not stored since it can't be compared
+// across restarts - the disk cache key includes the log file's fingerprint instead
+type persistedCacheEntry struct {
+	Output   grep.GrepOutput
+	Offset   int64
+	Boundary []byte
+}
+
+// Number of bytes at the start of the log file used to fingerprint it
+const FINGERPRINT_SIZE = 1024
+
+// Number of bytes before the cached offset that are compared to detect a truncated and rewritten file
+const BOUNDARY_CHECK_SIZE = 64
+
+// Size of the chunks read backwards from the end of the file when looking for the last new line
+const LINE_ALIGN_CHUNK_SIZE = 4096
+
+// Stats the log file and returns its info along with the number of bytes up to and including its last
+// new line character. Any partially written line at the end of the file is not counted, so that its
+// output isn't cached half written
+func snapshotLogFile(filename string) (os.FileInfo, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buff := make([]byte, LINE_ALIGN_CHUNK_SIZE)
+	end := fileInfo.Size()
+	for end > 0 {
+		chunkStart := end - LINE_ALIGN_CHUNK_SIZE
+		if chunkStart < 0 {
+			chunkStart = 0
+		}
+		chunk := buff[:end-chunkStart]
+		if _, err = file.ReadAt(chunk, chunkStart); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		if idx := bytes.LastIndexByte(chunk, '\n'); idx != -1 {
+			return fileInfo, chunkStart + int64(idx) + 1, nil
+		}
+		end = chunkStart
+	}
+
+	return fileInfo, 0, nil
+}
+
+// Reads the BOUNDARY_CHECK_SIZE bytes (or less at the start of the file) that end at offset
+func readBoundary(filename string, offset int64) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	start := offset - BOUNDARY_CHECK_SIZE
+	if start < 0 {
+		start = 0
+	}
+	buff := make([]byte, offset-start)
+	if _, err = file.ReadAt(buff, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buff, nil
+}
+
+// Creates a cache entry for the output of a query over the first offset bytes of the log file
+func newCacheEntry(filename string, output *grep.GrepOutput, fileInfo os.FileInfo, offset int64) *cacheEntry {
+	boundary, err := readBoundary(filename, offset)
+	if err != nil {
+		boundary = nil // entry will never be treated as a prefix, so it is simply rerun on the next hit
+	}
+	return &cacheEntry{output: output, offset: offset, fileInfo: fileInfo, boundary: boundary}
+}
+
+// Returns the approximate size in bytes of the entry, used to bound the memory taken up by the cache
+func (entry *cacheEntry) size() int64 {
+	return entry.output.SizeBytes() + int64(len(entry.boundary)) + CACHE_ENTRY_OVERHEAD_BYTES
+}
+
+// Returns true if the log file described by current is the same file the entry was created from
+// and it was only appended to since then. Returns false if the file was rotated (replaced by a new file)
+// or truncated, in which case the cached output is stale and the query has to be fully rerun
+func (entry *cacheEntry) isPrefixOf(filename string, current os.FileInfo, currentSize int64) bool {
+	if entry.boundary == nil || !os.SameFile(entry.fileInfo, current) || currentSize < entry.offset {
+		return false
+	}
+	boundary, err := readBoundary(filename, entry.offset)
+	return err == nil && bytes.Equal(boundary, entry.boundary)
+}
+
+// Returns a fingerprint identifying the log file across restarts: a hash of its absolute path and first
+// FINGERPRINT_SIZE bytes. A rotated log file starts with different lines, so it gets a different fingerprint
+func fingerprintLogFile(filename string) (string, error) {
+	absPath, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	buff := make([]byte, FINGERPRINT_SIZE)
+	n, err := io.ReadFull(file, buff)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(absPath))
+	hash.Write([]byte{0})
+	hash.Write(buff[:n])
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Serializes the entry to be stored in the disk cache
+func (entry *cacheEntry) serialize() ([]byte, error) {
+	binary_buff := new(bytes.Buffer)
+	encoder := gob.NewEncoder(binary_buff)
+	err := encoder.Encode(persistedCacheEntry{Output: *entry.output, Offset: entry.offset, Boundary: entry.boundary})
+	if err != nil {
+		return nil, err
+	}
+	return binary_buff.Bytes(), nil
+}
+
+// Deserializes an entry read from the disk cache. Since the entry was found under the fingerprint of
+// the current log file, it is given the current file info
+func deserializeCacheEntry(data []byte, fileInfo os.FileInfo) (*cacheEntry, error) {
+	var persisted persistedCacheEntry
+	decoder := gob.NewDecoder(bytes.NewBuffer(data))
+	if err := decoder.Decode(&persisted); err != nil {
+		return nil, err
+	}
+	persisted.Output.FillMissingCounts()
+	return &cacheEntry{output: &persisted.Output, offset: persisted.Offset, fileInfo: fileInfo, boundary: persisted.Boundary}, nil
+}
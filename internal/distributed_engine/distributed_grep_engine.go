@@ -2,18 +2,22 @@ package distributed_engine
 
 import (
 	"bufio"
+	"cs425_mp1/internal/cache"
 	"cs425_mp1/internal/grep"
 	"cs425_mp1/internal/network"
+	"cs425_mp1/internal/rpc"
 	"cs425_mp1/internal/utils"
 	"encoding/json"
 	"fmt"
-	lru "github.com/hashicorp/golang-lru"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 // DistributedEngine: Struct defining the distributed_engine to handle the Distributed Grep execution across
@@ -31,22 +35,41 @@ type DistributedGrepEngine struct {
 	clientConns      []net.Conn      // client connections to the peers
 	activeClients    map[string]bool // key = addr of client, value = True if connection is active. False if disconnected
 	numActiveClients int
+	clientsMutex     sync.Mutex               // guards activeClients and numActiveClients, which server goroutines update on disconnects
+	connMutexes      map[net.Conn]*sync.Mutex // one query at a time is sent on each client connection
+
+	grpcServer   *grpc.Server  // nil if this machine doesn't serve the gRPC service
+	grpcPeers    []*rpc.Client // gRPC clients of the peers, used instead of clientConns if not nil
+	queryTimeout time.Duration // deadline of the queries sent to peers over gRPC. 0 = none
+	httpServer   *http.Server  // nil if this machine doesn't serve the HTTP/JSON API
 
 	serverPort               string
 	peerAddresses            []string
 	localLogFile             string
 	testOutputFileNameFormat string
 
-	lruCache                *lru.Cache
+	executor    *grep.Executor // executes queries on the local log file with this machine's settings
+	machineName string         // host name of this machine, set as the Machine of its outputs
+
+	lruCache                *cache.Cache
+	diskCache               *cache.DiskCache // nil if results are not persisted to disk
 	cacheInitalizationError error
 
+	compressionThreshold int // outputs of at least this many bytes are compressed for peers that accept it. < 0 = never
+	networkStats         network.StatsRecorder
+
 	verbose            bool
+	mergedView         bool // print the lines of all machines merged in time order instead of one machine at a time
 	currentTestFileIdx int
+
+	nextPage      *grep.GrepQuery // query of the next page of the last query executed with --max-results, nil if none
+	nextPageMutex sync.Mutex
 }
 
 type JSONOutput struct {
-	Query   string            // packaged string of the grep query
-	Outputs []grep.GrepOutput // list of grep_outputs, each grep_output in this list is from a different vm
+	Query           string            // packaged string of the grep query
+	NormalizedQuery string            // normalized string of the grep query, i.e. the key its outputs are cached under
+	Outputs         []grep.GrepOutput // list of grep_outputs, each grep_output in this list is from a different vm
 }
 
 // you want to essentially create a list of these to store in JSON file
@@ -54,7 +77,7 @@ type JSONOutput struct {
 /*
 Creates a DistributedGrepEngine struct and initializes with default values
 */
-func CreateEngine(localLogFile string, serverPort string, peerAddresses []string, cacheSize int, verbose bool, testOutputFileNameFormat string) *DistributedGrepEngine {
+func CreateEngine(localLogFile string, serverPort string, peerAddresses []string, cacheConfig cache.Config, verbose bool, testOutputFileNameFormat string) *DistributedGrepEngine {
 	// initialize server and client connections here
 
 	// initialize cache
@@ -66,20 +89,51 @@ func CreateEngine(localLogFile string, serverPort string, peerAddresses []string
 	dpe.verbose = verbose
 	dpe.testOutputFileNameFormat = testOutputFileNameFormat
 	dpe.currentTestFileIdx = 1
+	dpe.executor = grep.NewExecutor(nil)
+	dpe.compressionThreshold = network.DEFAULT_COMPRESSION_THRESHOLD
+	if hostname, err := os.Hostname(); err == nil {
+		dpe.machineName = hostname
+	} else {
+		dpe.machineName = "localhost"
+	}
 
-	dpe.lruCache, dpe.cacheInitalizationError = lru.New(cacheSize)
+	dpe.lruCache, dpe.cacheInitalizationError = cache.New(cacheConfig)
 	if dpe.cacheInitalizationError != nil {
-		log.Fatalf("Error in initializing LRU Cache")
+		log.Fatalf("Error in initializing LRU Cache: %v", dpe.cacheInitalizationError)
+	}
+	if cacheConfig.DiskDir != "" {
+		dpe.diskCache, dpe.cacheInitalizationError = cache.NewDiskCache(cacheConfig.DiskDir, cacheConfig.DiskMaxBytes, cacheConfig.TTL)
+		if dpe.cacheInitalizationError != nil {
+			log.Fatalf("Error in initializing disk cache: %v", dpe.cacheInitalizationError)
+		}
 	}
 
 	return dpe
 }
 
+// Sets the executor used to execute queries on the local log file, ex: to parse its lines in a different log format
+func (dpe *DistributedGrepEngine) SetExecutor(executor *grep.Executor) {
+	dpe.executor = executor
+}
+
+// Sets the size in bytes from which outputs sent to peers are compressed, if the peer accepts it.
+// A negative threshold turns compression off, both for the outputs this machine sends and those it receives
+func (dpe *DistributedGrepEngine) SetCompressionThreshold(threshold int) {
+	dpe.compressionThreshold = threshold
+}
+
+// Sets whether Execute() prints the output lines of all machines merged in time order (see MergeOutputsByTime())
+// instead of each machine's output one after another
+func (dpe *DistributedGrepEngine) SetMergedView(mergedView bool) {
+	dpe.mergedView = mergedView
+}
+
 // Initialize all clients by connecting to all the remote servers (peers)
 // This function assumes that the Peers are already setup with their server running. That is,
 // It will only connect to the machines that have their servers setup
 func (dpe *DistributedGrepEngine) ConnectToPeers() {
 	dpe.clientConns = make([]net.Conn, 0) // connection objects of all the connected servers (peers)
+	dpe.connMutexes = make(map[net.Conn]*sync.Mutex)
 
 	// connect to each server's ipAddress (acting as client - connecting to the servers)
 	for _, peerServerAddr := range dpe.peerAddresses {
@@ -90,8 +144,11 @@ func (dpe *DistributedGrepEngine) ConnectToPeers() {
 				//fmt.Printf("Error connecting to %s: %v\n", peerServerAddr, err)
 				//continue
 				dpe.clientConns = append(dpe.clientConns, conn)
+				dpe.connMutexes[conn] = &sync.Mutex{}
+				dpe.clientsMutex.Lock()
 				dpe.activeClients[generateClientConnKey(conn)] = true
 				dpe.numActiveClients += 1
+				dpe.clientsMutex.Unlock()
 				didConnect = true
 			} else {
 				time.Sleep(125 * time.Millisecond) // wait 0.125 seconds before trying again to connect
@@ -140,137 +197,382 @@ func (dpe *DistributedGrepEngine) serve() {
 	}
 }
 
-// Handler for a connection that the server establishes with a foreign client
+// Handler for a connection that the server establishes with a foreign client: a peer, or a tool querying this
+// machine directly (see docs/protocol.md). Outputs are sent back in the encoding of the query, so peers running an
+// older version that send gob get gob back. Invalid queries get an output with the error instead of being executed
 func (dpe *DistributedGrepEngine) handleServerConnection(conn net.Conn) {
 	reader := bufio.NewReader(conn)
 	for {
 		gQueryData, read_err := network.ReadRequest(reader)
-		if read_err == io.EOF { // this server-client connection disconnected, so we can remove
+		if read_err == io.EOF { // this server-client connection disconnected
 			msg := fmt.Sprintf("\n**Client [%s] disconnected**\n", conn.RemoteAddr().String())
-			dpe.removeClient(conn)
 			utils.PrintMessage(msg, dpe.verbose)
 			return
 		} else if read_err != nil {
-			log.Fatalf("Error while performing network.ReadRequest()!")
+			log.Printf("Failed to read query from %s: %v", conn.RemoteAddr().String(), read_err)
+			return
 		}
 
+		var gOut *grep.GrepOutput
 		gQuery, err1 := grep.DeserializeGrepQuery(gQueryData)
+		if err1 == nil {
+			err1 = gQuery.Validate()
+		}
 		if err1 != nil {
-			log.Fatalf("Failed to Deserialize Grep Query: %v", err1)
+			gQuery = &grep.GrepQuery{}
+			gOut = &grep.GrepOutput{Filename: dpe.localLogFile, Machine: dpe.machineName, ExitStatus: grep.EXIT_ERROR,
+				Error: fmt.Sprintf("Invalid query: %v", err1)}
+		} else {
+			gOut = dpe.checkCacheOrExecute(gQuery) // retrieve output from cache or execute function if not in there
 		}
 
-		gOut := dpe.checkCacheOrExecute(gQuery) // retrieve output from cache or execute function if not in there
-
-		gOutData, err2 := grep.SerializeGrepOutput(gOut)
+		var gOutData []byte
+		var err2 error
+		if grep.IsGobEncoded(gQueryData) {
+			gOutData, err2 = grep.SerializeGrepOutputGob(gOut)
+		} else {
+			gOutData, err2 = grep.SerializeGrepOutput(gOut)
+		}
 		if err2 != nil {
 			log.Fatalf("Failed to Serialize Grep Output: %v", err2)
 		}
 
-		err := network.SendRequest(gOutData, conn)
+		var frameInfo network.FrameInfo
+		var err error
+		if gQuery.AcceptEncoding == network.ENCODING_GZIP && dpe.compressionThreshold >= 0 {
+			frameInfo, err = network.SendCompressed(gOutData, conn, dpe.compressionThreshold)
+		} else { // peer doesn't accept compressed frames, ex: it runs an older version
+			frameInfo, err = network.FrameInfo{Size: len(gOutData), WireSize: len(gOutData)}, network.SendRequest(gOutData, conn)
+		}
+		dpe.networkStats.RecordSent(frameInfo)
 		if err != nil {
-			log.Fatalf("SendRequest: Failed to send Grep Output Data to %s", conn.RemoteAddr().String())
+			log.Printf("SendRequest: Failed to send Grep Output Data to %s", conn.RemoteAddr().String())
+			return
 		}
 	}
 }
 
-// Helper function that first checks if the query is present in the cache.
-// If it is, it returns the output from the cache as well as updating the LRU position of the cache
-// Otherwise, it executes the grep query and stores output in the cache, and then returns the output
+// Returns the output of the query on the local log file from the cache, or by executing it (see lookupOrExecute()).
+// For a query with --max-results, only the page of the output it asks for is returned, taken from the output of the
+// query w/o the pagination options, so that the later pages are served from the cache instead of grepping again
 func (dpe *DistributedGrepEngine) checkCacheOrExecute(gQuery *grep.GrepQuery) *grep.GrepOutput {
-	var cacheValue interface{}
+	page, hasPage, err := gQuery.Page()
+	if err != nil { // queries are validated when created, so only a corrupted query gets here
+		return &grep.GrepOutput{Filename: dpe.localLogFile, Machine: dpe.machineName, ExitStatus: grep.EXIT_ERROR, Error: err.Error()}
+	}
+	if !hasPage {
+		return dpe.lookupOrExecute(gQuery)
+	}
+	return page.Apply(dpe.lookupOrExecute(gQuery.WithoutPagination()), dpe.machineName)
+}
 
+// Helper function that first checks if the query is present in the cache (in memory, then on disk).
+// If it is, it returns the output from the cache as well as updating the LRU position of the cache.
+// If the log file grew since the output was cached, only the appended bytes are grepped and merged with the
+// cached output. If the file was truncated or rotated, or the query cannot be merged, the query is rerun.
+// Otherwise, it executes the grep query and stores output in the cache, and then returns the output.
+// A partially written last line is always grepped, but for queries that can be merged only the output up to the
+// last complete line is cached, so that the line is grepped again once the rest of it is written
+func (dpe *DistributedGrepEngine) lookupOrExecute(gQuery *grep.GrepQuery) *grep.GrepOutput {
 	var gOut *grep.GrepOutput
-	var ok bool
-
-	cacheKey := gQuery.PackagedString
-	if dpe.lruCache.Contains(cacheKey) {
-		start := time.Now()
-		cacheValue, ok = dpe.lruCache.Get(cacheKey)
-		if !ok {
-			log.Fatalf("Error in getting cache value: lruCache.Get(%s)", cacheKey)
+
+	fileInfo, alignedSize, statErr := snapshotLogFile(dpe.localLogFile)
+	if statErr != nil { // can't track offsets of the file, so don't cache
+		gOut = dpe.executor.Execute(gQuery, dpe.localLogFile)
+		gOut.Machine = dpe.machineName
+		return gOut
+	}
+	fileSize := fileInfo.Size()
+	cachedSize := fileSize // number of bytes of the log file the cached output covers
+	mergeable := gQuery.SupportsIncrementalMerge()
+	if mergeable { // the cached output must end on a line boundary to merge the tail with it
+		cachedSize = alignedSize
+	}
+
+	cacheKey := gQuery.CacheKey()
+	start := time.Now()
+	entry, ok := dpe.getCacheEntry(cacheKey, fileInfo)
+	if ok {
+		isPrefix := entry.isPrefixOf(dpe.localLogFile, fileInfo, cachedSize)
+
+		if isPrefix && entry.offset == cachedSize {
+			// the cached output is shared with other queries, so return a copy instead of modifying it
+			gOut = entry.output.AsCacheHit(time.Now().Sub(start))
+		} else if isPrefix && mergeable { // log file was appended to, so only grep the new tail
+			tailOut := dpe.executor.ExecuteRange(gQuery, dpe.localLogFile, entry.offset, cachedSize)
+			if tailOut.Failed() { // keep the cached output, which is still valid for the prefix
+				gOut = tailOut
+			} else {
+				merged := gQuery.MergeIncrementalOutputs(entry.output, tailOut)
+				dpe.storeCacheEntry(cacheKey, newCacheEntry(dpe.localLogFile, merged, fileInfo, cachedSize))
+				gOut = merged.AsCacheHit(time.Now().Sub(start))
+			}
+		} else { // log file was truncated or rotated, or the query can't be merged
+			gOut = dpe.executeAndCache(gQuery, fileInfo, cachedSize)
 		}
-		gOut = cacheValue.(*grep.GrepOutput)
-		end := time.Now()
-		elapsed := end.Sub(start)
-		gOut.ExecutionTime = elapsed // update exec time since we now got it from cache
 	} else {
-		gOut = gQuery.Execute(dpe.localLogFile)
-		dpe.lruCache.Add(cacheKey, gOut)
+		gOut = dpe.executeAndCache(gQuery, fileInfo, cachedSize)
+	}
+
+	if cachedSize < fileSize && !gOut.Failed() { // grep the partial last line, w/o caching its output
+		partialOut := dpe.executor.ExecuteRange(gQuery, dpe.localLogFile, cachedSize, fileSize)
+		if partialOut.Failed() {
+			gOut = partialOut
+		} else {
+			merged := gQuery.MergeIncrementalOutputs(gOut, partialOut)
+			merged.CacheHit, merged.CacheLookupTime = gOut.CacheHit, gOut.CacheLookupTime
+			gOut = merged
+		}
 	}
 
+	gOut.Machine = dpe.machineName // gOut is never the cached output, so this doesn't modify the cache
 	return gOut
 }
 
+// Executes the grep query on the first fileSize bytes of the log file and stores the output in the cache
+// unless the execution failed. Returns a copy of the cached output so the caller can't modify the cached one.
+// If fileSize is the size of the whole file, grep reads the file itself instead of having the range piped to it,
+// and the output is only cached if the file didn't grow in the meantime, since it may then cover more bytes
+func (dpe *DistributedGrepEngine) executeAndCache(gQuery *grep.GrepQuery, fileInfo os.FileInfo, fileSize int64) *grep.GrepOutput {
+	if fileSize < fileInfo.Size() { // only the lines up to the partial last line are cached
+		gOut := dpe.executor.ExecuteRange(gQuery, dpe.localLogFile, 0, fileSize)
+		if gOut.Failed() { // the error may be temporary (ex: permissions), so it isn't cached
+			return gOut
+		}
+		return dpe.cacheOutput(gQuery, gOut, fileInfo, fileSize)
+	}
+
+	gOut := dpe.executor.Execute(gQuery, dpe.localLogFile)
+	if gOut.Failed() {
+		return gOut
+	}
+	if info, err := os.Stat(dpe.localLogFile); err != nil || info.Size() != fileSize {
+		return gOut
+	}
+	return dpe.cacheOutput(gQuery, gOut, fileInfo, fileSize)
+}
+
+// Helper function to store the output of the query on the first fileSize bytes of the log file in the cache,
+// and return a copy of it
+func (dpe *DistributedGrepEngine) cacheOutput(gQuery *grep.GrepQuery, gOut *grep.GrepOutput, fileInfo os.FileInfo, fileSize int64) *grep.GrepOutput {
+	dpe.storeCacheEntry(gQuery.CacheKey(), newCacheEntry(dpe.localLogFile, gOut, fileInfo, fileSize))
+	outCopy := *gOut
+	return &outCopy
+}
+
+// Looks up the cache entry for the key in the in-memory cache, and then in the disk cache if there is one.
+// Entries found on disk are added to the in-memory cache with the time they were stored, so they still
+// expire once they outlive the TTL
+func (dpe *DistributedGrepEngine) getCacheEntry(cacheKey string, fileInfo os.FileInfo) (*cacheEntry, bool) {
+	if cacheValue, ok := dpe.lruCache.Get(cacheKey); ok {
+		return cacheValue.(*cacheEntry), true
+	}
+	if dpe.diskCache == nil {
+		return nil, false
+	}
+
+	diskKey, err := dpe.diskCacheKey(cacheKey)
+	if err != nil {
+		return nil, false
+	}
+	data, addedAt, ok := dpe.diskCache.Get(diskKey)
+	if !ok {
+		return nil, false
+	}
+	entry, err := deserializeCacheEntry(data, fileInfo)
+	if err != nil {
+		return nil, false
+	}
+
+	dpe.lruCache.AddAt(cacheKey, entry, entry.size(), addedAt)
+	return entry, true
+}
+
+// Stores the cache entry in the in-memory cache, and writes it through to the disk cache if there is one.
+// Entries rejected by the in-memory cache for being too large are not written to disk either
+func (dpe *DistributedGrepEngine) storeCacheEntry(cacheKey string, entry *cacheEntry) {
+	if !dpe.lruCache.Add(cacheKey, entry, entry.size()) || dpe.diskCache == nil {
+		return
+	}
+
+	diskKey, err := dpe.diskCacheKey(cacheKey)
+	if err != nil {
+		return
+	}
+	data, err := entry.serialize()
+	if err != nil {
+		log.Printf("Failed to serialize cache entry: %v", err)
+		return
+	}
+	if err = dpe.diskCache.Put(diskKey, data); err != nil {
+		log.Printf("Failed to write cache entry to disk: %v", err)
+	}
+}
+
+// Returns the key of a query's output in the disk cache: the query's cache key plus the log file's fingerprint
+func (dpe *DistributedGrepEngine) diskCacheKey(cacheKey string) (string, error) {
+	fingerprint, err := fingerprintLogFile(dpe.localLogFile)
+	if err != nil {
+		return "", err
+	}
+	return fingerprint + "\x00" + cacheKey, nil
+}
+
+// Outputs of a query on every machine, with the totals Execute() prints
+type DistributedOutput struct {
+	NormalizedQuery   string                // canonical command args of the query, packaged (see grep.PackageCmdArgs())
+	Outputs           []grep.GrepOutput     // output of this machine, then the outputs of the peers
+	TotalLines        int                   // matching lines of all machines, not counting context lines
+	TotalContextLines int                   // context lines of all machines, printed with -A, -B or -C
+	FailedMachines    int                   // number of machines whose query failed
+	Aggregates        []grep.AggregateCount // counts of all machines added up, only for --count-by queries
+	NextPage          []string              // command args of the next page with --max-results. nil if there is none
+	ElapsedTime       time.Duration
+}
+
 /*
 Execute the grep query on local machine and all peer machines by sending grep query
-to all peer machines and receive back output from them
+to all peer machines and receive back output from them, and returns the outputs of all machines with their totals.
+Safe to call from multiple goroutines at once, ex: to serve HTTP requests while queries are typed in.
 
-Prints the output from each machine to stdout in a nice formatted manner
-Additionally prints the total number of lines at the end
+onOutput is called (if not nil) with each machine's output as soon as it is received, in the order of Outputs.
+With --max-results, the outputs are cut down to that many lines in total only once every machine's output is in,
+so the outputs passed to onOutput are the whole outputs
 */
-func (dpe *DistributedGrepEngine) Execute(gquery *grep.GrepQuery) {
-	var outputsJson = make([]grep.GrepOutput, 0)
+func (dpe *DistributedGrepEngine) ExecuteAll(gquery *grep.GrepQuery, onOutput func(gOut *grep.GrepOutput)) *DistributedOutput {
+	result := &DistributedOutput{NormalizedQuery: gquery.CacheKey(), Outputs: make([]grep.GrepOutput, 0)}
 
 	start := time.Now()
-	numTotalPeerConnections := len(dpe.clientConns)
 	localChannel := make(chan *grep.GrepOutput)
-	var totalNumLines int
-
-	peerChannels := make([]chan *grep.GrepOutput, dpe.numActiveClients)
-	for i := 0; i < dpe.numActiveClients; i++ {
-		peerChannels[i] = make(chan *grep.GrepOutput)
-	}
 
 	// launch goroutines for local and remote executions to all run in parallel
 	go dpe.localExecute(gquery, localChannel)
 
-	var peerChannelIdx = 0
-	for i := 0; i < numTotalPeerConnections; i++ {
-		currConn := dpe.clientConns[i]
-		if dpe.activeClients[generateClientConnKey(currConn)] == true {
-			go dpe.remoteExecute(gquery, currConn, peerChannels[peerChannelIdx])
-			peerChannelIdx += 1
+	peerChannels := make([]chan *grep.GrepOutput, 0)
+	if dpe.grpcPeers != nil {
+		for _, peer := range dpe.grpcPeers {
+			peerChannel := make(chan *grep.GrepOutput)
+			peerChannels = append(peerChannels, peerChannel)
+			go dpe.grpcExecute(gquery, peer, peerChannel)
+		}
+	} else {
+		for _, currConn := range dpe.activeClientConns() {
+			peerChannel := make(chan *grep.GrepOutput)
+			peerChannels = append(peerChannels, peerChannel)
+			go dpe.remoteExecute(gquery, currConn, peerChannel)
 		}
 	}
 
 	// * NOTE: localExecute() and remoteExecute() will not exit until its respective channels are read from since the channels
 	// * once written to will block until someone reads from them. Therefore, it will block until it is read from below
+	for _, channel := range append([]chan *grep.GrepOutput{localChannel}, peerChannels...) {
+		grepOut := <-channel
+		result.TotalLines += grepOut.MatchCount
+		result.TotalContextLines += grepOut.ContextLines
+		if grepOut.Failed() {
+			result.FailedMachines++
+		}
 
-	// Print local grep output to stdout
-	grepOut := <-localChannel
-	totalNumLines += grepOut.NumLines
+		result.Outputs = append(result.Outputs, *grepOut)
+		if onOutput != nil {
+			onOutput(grepOut)
+		}
+	}
 
-	outputsJson = append(outputsJson, *grepOut)
-	fmt.Print(grepOut.ToString())
+	if page, hasPage, _ := gquery.Page(); hasPage {
+		if nextCursor := page.LimitResults(result.Outputs); nextCursor != "" {
+			result.NextPage = gquery.WithCursor(nextCursor).CmdArgs
+		}
+	}
+	if gquery.AggregateKeys() != nil { // combine the counts of all machines
+		result.Aggregates = grep.CombineAggregates(gquery, result.Outputs)
+	}
 
-	// Print peer grep outputs to stdout
-	for i := 0; i < len(peerChannels); i++ {
-		grepOut := <-peerChannels[i] // read from channel into grep outputs array
-		totalNumLines += grepOut.NumLines
+	result.ElapsedTime = time.Now().Sub(start)
+	return result
+}
 
-		outputsJson = append(outputsJson, *grepOut)
-		fmt.Print(grepOut.ToString())
+/*
+Execute the grep query on all machines (see ExecuteAll())
+
+Prints the output from each machine to stdout in a nice formatted manner
+Additionally prints the total number of lines at the end, and the errors of machines that failed to stderr.
+With --max-results, the outputs are cut down to that many lines in total, and the query of the next page is printed
+(see NextPage())
+*/
+func (dpe *DistributedGrepEngine) Execute(gquery *grep.GrepQuery) {
+	// outputs of a page can only be printed once every machine's output is in and they are cut down to the page
+	_, hasPage, _ := gquery.Page()
+	printAsReceived := !dpe.mergedView && !hasPage
+
+	result := dpe.ExecuteAll(gquery, func(grepOut *grep.GrepOutput) {
+		if printAsReceived {
+			fmt.Print(grepOut.ToString())
+		}
+	})
+
+	if hasPage && !dpe.mergedView {
+		for i := range result.Outputs {
+			fmt.Print(result.Outputs[i].ToString())
+		}
 	}
 
-	end := time.Now()
+	// errors go to stderr (after the outputs) so they can't be mistaken for matching lines
+	for _, gOut := range result.Outputs {
+		if gOut.Failed() {
+			_, _ = fmt.Fprintf(os.Stderr, "Error on %s (%s): %s\n", gOut.Machine, gOut.Filename, gOut.Error)
+		}
+	}
+
+	if keyNames := gquery.AggregateKeys(); keyNames != nil {
+		fmt.Printf("Aggregate Output:\n%s\n", grep.FormatAggregates(keyNames, result.Aggregates))
+	} else if dpe.mergedView { // the lines can only be merged once every machine's output is in
+		fmt.Printf("Merged Output:\n%s\n", MergeOutputsByTime(result.Outputs, dpe.executor))
+	}
 
 	if dpe.testOutputFileNameFormat != "" {
-		_, err := dpe.CreateJson(gquery.PackagedString, outputsJson)
+		_, err := dpe.CreateJson(gquery.PackagedString, result.Outputs)
 		dpe.currentTestFileIdx += 1
 		if err != nil {
 			fmt.Println("Error in creating json file ")
 		}
 	}
 
-	elapsed := end.Sub(start)
-	fmt.Printf("Total Number of Lines: %d\n", totalNumLines)
-	fmt.Printf("Elapsed Query Execution Time: %dns\n\n", elapsed.Nanoseconds())
+	fmt.Printf("Normalized Query: %s\n", grep.QuoteShellArgs(grep.CanonicalizeArgs(gquery.CmdArgs)))
+	fmt.Printf("Total Number of Lines: %d\n", result.TotalLines)
+	if result.TotalContextLines > 0 {
+		fmt.Printf("Total Number of Context Lines: %d\n", result.TotalContextLines)
+	}
+	if result.FailedMachines > 0 {
+		fmt.Printf("Failed Machines: %d of %d\n", result.FailedMachines, len(result.Outputs))
+	}
+	if hasPage {
+		dpe.nextPageMutex.Lock()
+		dpe.nextPage = nil
+		if result.NextPage != nil {
+			dpe.nextPage = grep.CreateGrepQueryFromPackagedString(grep.PackageCmdArgs(result.NextPage))
+			fmt.Printf("Next Page (or enter \"next\"): %s\n", grep.QuoteShellArgs(dpe.nextPage.CmdArgs))
+		} else {
+			fmt.Println("No More Results")
+		}
+		dpe.nextPageMutex.Unlock()
+	}
+	fmt.Printf("Elapsed Query Execution Time: %dns\n\n", result.ElapsedTime.Nanoseconds())
+}
+
+// Returns the query of the next page of the last query executed with --max-results, and false if every line
+// of it was already returned
+func (dpe *DistributedGrepEngine) NextPage() (*grep.GrepQuery, bool) {
+	dpe.nextPageMutex.Lock()
+	defer dpe.nextPageMutex.Unlock()
+	return dpe.nextPage, dpe.nextPage != nil
 }
 
 func (dpe *DistributedGrepEngine) CreateJson(packagedString string, outputsJson []grep.GrepOutput) ([]byte, error) {
 	data := JSONOutput{
-		Query:   packagedString,
-		Outputs: outputsJson,
+		Query:           packagedString,
+		NormalizedQuery: grep.CreateGrepQueryFromPackagedString(packagedString).NormalizedString,
+		Outputs:         outputsJson,
 	}
 
 	dataBytes, err := json.MarshalIndent(data, "", " ")
@@ -287,16 +589,13 @@ func (dpe *DistributedGrepEngine) CreateJson(packagedString string, outputsJson
 	return dataBytes, err
 }
 
+// Reads a JSON file created by CreateJson() and returns its query and grep outputs.
+// Queries packaged in the legacy format (ex: "grep;-c;GET") are migrated to the current format
 func DeserializeJson(jsonFileName string) (string, []grep.GrepOutput) {
-	jsonFile, err := os.Open(jsonFileName)
+	dataBytes, err := os.ReadFile(jsonFileName)
 	if err != nil {
 		log.Fatalf("Failed to open json file")
 	}
-	defer func(jsonFile *os.File) {
-		_ = jsonFile.Close()
-	}(jsonFile)
-
-	dataBytes, _ := io.ReadAll(jsonFile)
 
 	var jsonOutput JSONOutput
 	err2 := json.Unmarshal(dataBytes, &jsonOutput)
@@ -305,9 +604,42 @@ func DeserializeJson(jsonFileName string) (string, []grep.GrepOutput) {
 		log.Fatalf("Error in deserializing json file")
 	}
 
+	jsonOutput.migrateQuery()
 	return jsonOutput.Query, jsonOutput.Outputs
 }
 
+// Rewrites a JSON file created by CreateJson() with its query packaged in the current format, so files
+// created before the packaging format changed can be compared to new ones. Returns true if the file changed
+func MigrateJsonFile(jsonFileName string) (bool, error) {
+	dataBytes, err := os.ReadFile(jsonFileName)
+	if err != nil {
+		return false, err
+	}
+
+	var jsonOutput JSONOutput
+	if err = json.Unmarshal(dataBytes, &jsonOutput); err != nil {
+		return false, err
+	}
+	if !jsonOutput.migrateQuery() {
+		return false, nil
+	}
+
+	migratedBytes, err := json.MarshalIndent(jsonOutput, "", " ")
+	if err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(jsonFileName, migratedBytes, os.FileMode(0644))
+}
+
+// Converts the query (and normalized query) to the current packaging format. Returns true if either changed
+func (jsonOutput *JSONOutput) migrateQuery() bool {
+	migrated := grep.CreateGrepQueryFromPackagedString(jsonOutput.Query)
+	changed := jsonOutput.Query != migrated.PackagedString || jsonOutput.NormalizedQuery != migrated.NormalizedString
+	jsonOutput.Query = migrated.PackagedString
+	jsonOutput.NormalizedQuery = migrated.NormalizedString
+	return changed
+}
+
 /*
 Execute a grep query on a remote machine by sending the query to the machine
 and waiting to receive the output and then returning it.
@@ -321,38 +653,68 @@ Parameters:
 	outputChannel: channel that remoteExecute() will send its grep output to
 */
 func (dpe *DistributedGrepEngine) remoteExecute(gquery *grep.GrepQuery, conn net.Conn, outputChannel chan *grep.GrepOutput) {
-	gquery_data, ser_err := grep.SerializeGrepQuery(gquery)
+	request := *gquery // gquery is shared with the other goroutines, so set the encoding on a copy
+	if dpe.compressionThreshold >= 0 {
+		request.AcceptEncoding = network.ENCODING_GZIP
+	}
+	gquery_data, ser_err := grep.SerializeGrepQuery(&request)
 	if ser_err != nil {
 		log.Fatalf("Failed to serialized gquery data")
 	}
 
+	// the output is read back on the same connection, so queries sent at the same time (ex: over HTTP) take turns
+	connMutex := dpe.connMutexes[conn]
+	connMutex.Lock()
+	defer connMutex.Unlock()
+
 	err := network.SendRequest(gquery_data, conn)
 	if err != nil {
-		fmt.Printf("Failed to send gquery_data to %s\n", conn.RemoteAddr())
+		dpe.removeClient(conn) // peer is down, so stop sending it queries
+		outputChannel <- remoteErrorOutput(conn, fmt.Sprintf("Failed to send query: %v", err))
 		return
 	}
 
 	// wait to recv data back
 	reader := bufio.NewReader(conn)
-	byte_data, err2 := network.ReadRequest(reader)
+	byte_data, frameInfo, err2 := network.ReadFrame(reader)
 	if err2 != nil {
-		fmt.Printf("Failed to read gquery_data from %s\n", conn.RemoteAddr())
+		dpe.removeClient(conn)
+		outputChannel <- remoteErrorOutput(conn, fmt.Sprintf("Failed to read output: %v", err2))
 		return
 	}
 
+	dpe.networkStats.RecordReceived(frameInfo)
+
 	grepOutput, err1 := grep.DeserializeGrepOutput(byte_data)
 	if err1 != nil {
-		log.Fatalf("Failed to Deserialize Grep Output: %v", err1)
+		outputChannel <- remoteErrorOutput(conn, fmt.Sprintf("Failed to deserialize output: %v", err1))
+		return
 	}
+	if grepOutput.Machine == "" { // peer is running an older version that doesn't set it
+		grepOutput.Machine = generateClientConnKey(conn)
+	}
+	grepOutput.FillMissingCounts()
 
 	outputChannel <- grepOutput
 }
 
+// Returns the output of a query on a peer that failed because of the connection to it. Every peer sends back
+// an output so that Execute() never waits forever on a failed peer
+func remoteErrorOutput(conn net.Conn, errMsg string) *grep.GrepOutput {
+	return &grep.GrepOutput{Machine: generateClientConnKey(conn), ExitStatus: grep.EXIT_ERROR, Error: errMsg}
+}
+
 func (dpe *DistributedGrepEngine) localExecute(gquery *grep.GrepQuery, outputChannel chan *grep.GrepOutput) {
 	grepOutput := dpe.checkCacheOrExecute(gquery)
 	outputChannel <- grepOutput
 }
 
+// Executes the grep query on this machine's log file only, using the cache.
+// Safe to call from multiple goroutines at once
+func (dpe *DistributedGrepEngine) ExecuteLocal(gquery *grep.GrepQuery) *grep.GrepOutput {
+	return dpe.checkCacheOrExecute(gquery)
+}
+
 // Does not work currently so do not use
 //func (dpe *DistributedGrepEngine) Shutdown() {
 //	dpe.StopServer()
@@ -368,13 +730,57 @@ func (dpe *DistributedGrepEngine) StopServer() {
 		log.Fatal("Failed to close server's listener object")
 	}
 	dpe.serverWg.Wait()
+	if dpe.grpcServer != nil {
+		dpe.grpcServer.Stop()
+	}
+	if dpe.httpServer != nil {
+		_ = dpe.httpServer.Close()
+	}
+}
+
+// Returns the usage stats of this machine's in-memory cache (hits, misses, evictions, size, ...)
+func (dpe *DistributedGrepEngine) CacheStats() cache.Stats {
+	return dpe.lruCache.Stats()
+}
+
+// Returns the usage stats of this machine's disk cache. Returns false if there is no disk cache
+func (dpe *DistributedGrepEngine) DiskCacheStats() (cache.Stats, bool) {
+	if dpe.diskCache == nil {
+		return cache.Stats{}, false
+	}
+	return dpe.diskCache.Stats(), true
+}
+
+// Returns the stats of the outputs this machine sent to and received from its peers, including the bytes saved
+// by compressing them
+func (dpe *DistributedGrepEngine) NetworkStats() network.Stats {
+	return dpe.networkStats.Stats()
 }
 
-// When a client was disconnected, call this function to remove
-// the client information from the DistributedGrepEngine struct
+// When the connection to a peer failed, call this function to remove
+// the client information from the DistributedGrepEngine struct, so the peer isn't sent queries anymore
 func (dpe *DistributedGrepEngine) removeClient(conn net.Conn) {
-	dpe.activeClients[generateClientConnKey(conn)] = false
-	dpe.numActiveClients -= 1
+	dpe.clientsMutex.Lock()
+	defer dpe.clientsMutex.Unlock()
+	key := generateClientConnKey(conn)
+	if dpe.activeClients[key] {
+		dpe.activeClients[key] = false
+		dpe.numActiveClients -= 1
+	}
+}
+
+// Returns the client connections to the peers that are still active
+func (dpe *DistributedGrepEngine) activeClientConns() []net.Conn {
+	dpe.clientsMutex.Lock()
+	defer dpe.clientsMutex.Unlock()
+
+	conns := make([]net.Conn, 0, dpe.numActiveClients)
+	for _, conn := range dpe.clientConns {
+		if dpe.activeClients[generateClientConnKey(conn)] {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
 }
 
 // Generate a key for a connection object for the client
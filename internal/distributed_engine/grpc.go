@@ -0,0 +1,120 @@
+package distributed_engine
+
+import (
+	"context"
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/rpc"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Serves the LogQuery gRPC service (see docs/protocol.md) on addr, ex: ":9001", on a separate goroutine.
+// The raw TCP server (see InitializeServer()) keeps serving the peers and clients that use it
+func (dpe *DistributedGrepEngine) InitializeGrpcServer(addr string) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("net.Listen(): %v", err)
+	}
+	dpe.grpcServer = grpc.NewServer()
+	rpc.RegisterLogQueryServer(dpe.grpcServer, dpe)
+	go func() {
+		if err := dpe.grpcServer.Serve(l); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+}
+
+// Creates gRPC clients of the peers, which Execute() then sends queries to instead of using the raw TCP
+// connections (see ConnectToPeers()). Unlike ConnectToPeers(), this doesn't wait for the peers to be up:
+// a peer that is down fails the queries sent to it until it is back up
+func (dpe *DistributedGrepEngine) ConnectToGrpcPeers(peerAddresses []string) {
+	dpe.grpcPeers = make([]*rpc.Client, 0, len(peerAddresses))
+	for _, addr := range peerAddresses {
+		client, err := rpc.NewClient(addr)
+		if err != nil {
+			log.Fatalf("Invalid gRPC address %s: %v", addr, err)
+		}
+		dpe.grpcPeers = append(dpe.grpcPeers, client)
+	}
+}
+
+// Sets the deadline of the queries sent to peers over gRPC. Peers that haven't sent back their output by then
+// are reported as failed. 0 = no deadline
+func (dpe *DistributedGrepEngine) SetQueryTimeout(timeout time.Duration) {
+	dpe.queryTimeout = timeout
+}
+
+// Query of the LogQuery service: runs the query on the local log file and streams back its output
+func (dpe *DistributedGrepEngine) Query(request *rpc.QueryRequest, stream rpc.QueryStream) error {
+	gQuery, err := grep.CreateGrepQueryFromArgs(request.CmdArgs)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Invalid query: %v", err)
+	}
+	gOut := dpe.checkCacheOrExecute(gQuery)
+	if err = stream.Context().Err(); err != nil { // caller gave up while grepping
+		return status.FromContextError(err).Err()
+	}
+	if err = rpc.SendOutput(stream, gOut); err != nil {
+		return status.Convert(err).Err()
+	}
+	return nil
+}
+
+// Stats of the LogQuery service: same stats as the "stats" command prints
+func (dpe *DistributedGrepEngine) Stats(ctx context.Context, request *rpc.StatsRequest) (*rpc.StatsReply, error) {
+	reply := &rpc.StatsReply{Machine: dpe.machineName, Cache: dpe.CacheStats(), Network: dpe.NetworkStats()}
+	if diskStats, ok := dpe.DiskCacheStats(); ok {
+		reply.DiskCache = &diskStats
+	}
+	if indexStats, ok := dpe.executor.IndexStats(); ok {
+		reply.Index = &indexStats
+	}
+	return reply, nil
+}
+
+// Health of the LogQuery service: a machine serves queries as long as its log file can be read
+func (dpe *DistributedGrepEngine) Health(ctx context.Context, request *rpc.HealthRequest) (*rpc.HealthReply, error) {
+	reply := &rpc.HealthReply{Machine: dpe.machineName, Status: rpc.STATUS_SERVING}
+	info, err := os.Stat(dpe.localLogFile)
+	if err != nil {
+		reply.Status = rpc.STATUS_NOT_SERVING
+		reply.Error = err.Error()
+	} else {
+		reply.LogFileBytes = info.Size()
+	}
+	return reply, nil
+}
+
+/*
+Execute a grep query on a remote machine over gRPC, like remoteExecute() does over the raw TCP connection.
+
+Designed to be ran as a goroutine. Sends an output with the error to outputChannel if the call failed,
+ex: the peer is down or didn't answer before the query timeout (see SetQueryTimeout())
+*/
+func (dpe *DistributedGrepEngine) grpcExecute(gquery *grep.GrepQuery, peer *rpc.Client, outputChannel chan *grep.GrepOutput) {
+	ctx := context.Background()
+	if dpe.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dpe.queryTimeout)
+		defer cancel()
+	}
+
+	peerHost, _, _ := net.SplitHostPort(peer.Addr())
+	grepOutput, err := peer.Query(ctx, gquery.CmdArgs)
+	if err != nil {
+		outputChannel <- &grep.GrepOutput{Machine: peerHost, ExitStatus: grep.EXIT_ERROR, Error: status.Convert(err).Message()}
+		return
+	}
+	if grepOutput.Machine == "" {
+		grepOutput.Machine = peerHost
+	}
+	grepOutput.FillMissingCounts()
+
+	outputChannel <- grepOutput
+}
@@ -0,0 +1,138 @@
+package distributed_engine
+
+import (
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/rpc"
+	"cs425_mp1/internal/webui"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Max size in bytes of the body of a POST /query request
+const MAX_QUERY_BODY_SIZE = 1 << 20
+
+// Max time a client has to send the headers of a request, so that slow clients can't hold connections open
+const HTTP_READ_HEADER_TIMEOUT = 10 * time.Second
+
+// Host the HTTP API binds to when the address has none, ex: ":8080". The API has no authentication, so it is
+// only reachable from other machines when it is given a host to bind to explicitly, ex: "0.0.0.0:8080"
+const DEFAULT_HTTP_HOST = "127.0.0.1"
+
+// Body of a POST /query request. The query is either given split into args, or as typed in the terminal
+type HttpQueryRequest struct {
+	CmdArgs []string // ex: ["grep", "-c", "ERROR"]
+	Query   string   // ex: "grep -c ERROR", only used if CmdArgs is empty
+}
+
+// Body of the responses to failed requests
+type HttpError struct {
+	Error string
+}
+
+// Serves the HTTP/JSON API on addr, ex: ":8080", on a separate goroutine:
+//
+//	POST /query          runs a query on all machines and returns a DistributedOutput
+//	GET  /local/query?q= runs a query (ex: q=grep -c ERROR) on this machine's log file only and returns a GrepOutput
+//	GET  /health         returns whether this machine can serve queries (503 if not)
+//	GET  /stream?q=      streams the lines matching a query on all machines as they are logged (see Follow())
+//	GET  /               web UI to run queries from a browser (see internal/webui)
+//
+// Invalid queries get a 400 with an HttpError. An addr w/o a host binds to DEFAULT_HTTP_HOST
+func (dpe *DistributedGrepEngine) InitializeHttpServer(addr string) {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort(DEFAULT_HTTP_HOST, port)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("net.Listen(): %v", err)
+	}
+	dpe.httpServer = &http.Server{Handler: dpe.HttpHandler(), ReadHeaderTimeout: HTTP_READ_HEADER_TIMEOUT}
+	go func() {
+		if err := dpe.httpServer.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP server stopped: %v", err)
+		}
+	}()
+}
+
+// Returns the handler of the HTTP/JSON API (see InitializeHttpServer())
+func (dpe *DistributedGrepEngine) HttpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/query", allowMethod(http.MethodPost, http.HandlerFunc(dpe.handleHttpQuery)))
+	mux.Handle("/local/query", allowMethod(http.MethodGet, http.HandlerFunc(dpe.handleHttpLocalQuery)))
+	mux.Handle("/health", allowMethod(http.MethodGet, http.HandlerFunc(dpe.handleHttpHealth)))
+	mux.Handle("/stream", allowMethod(http.MethodGet, http.HandlerFunc(dpe.handleHttpStream)))
+	mux.Handle("/", allowMethod(http.MethodGet, webui.Handler()))
+	return mux
+}
+
+// Wraps the handler so that requests with another method get a 405 with an HttpError. GET also allows HEAD.
+// Methods are checked here instead of in the mux patterns, which only support them since Go 1.22
+func allowMethod(method string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+			w.Header().Set("Allow", method)
+			writeJson(w, http.StatusMethodNotAllowed, HttpError{Error: "Method not allowed, use " + method})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (dpe *DistributedGrepEngine) handleHttpQuery(w http.ResponseWriter, r *http.Request) {
+	var request HttpQueryRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_QUERY_BODY_SIZE))
+	if err := decoder.Decode(&request); err != nil {
+		writeJson(w, http.StatusBadRequest, HttpError{Error: "Invalid request body: " + err.Error()})
+		return
+	}
+
+	var gQuery *grep.GrepQuery
+	var err error
+	if len(request.CmdArgs) > 0 {
+		gQuery, err = grep.CreateGrepQueryFromArgs(request.CmdArgs)
+	} else {
+		gQuery, err = grep.CreateGrepQueryFromInput(request.Query)
+	}
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, HttpError{Error: "Invalid query: " + err.Error()})
+		return
+	}
+	writeJson(w, http.StatusOK, dpe.ExecuteAll(gQuery, nil))
+}
+
+func (dpe *DistributedGrepEngine) handleHttpLocalQuery(w http.ResponseWriter, r *http.Request) {
+	input := r.URL.Query().Get("q")
+	if input == "" {
+		writeJson(w, http.StatusBadRequest, HttpError{Error: "Missing query, ex: ?q=grep -c ERROR"})
+		return
+	}
+	gQuery, err := grep.CreateGrepQueryFromInput(input)
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, HttpError{Error: "Invalid query: " + err.Error()})
+		return
+	}
+	writeJson(w, http.StatusOK, dpe.ExecuteLocal(gQuery))
+}
+
+func (dpe *DistributedGrepEngine) handleHttpHealth(w http.ResponseWriter, r *http.Request) {
+	health, _ := dpe.Health(r.Context(), &rpc.HealthRequest{}) // same health as the gRPC service
+	if health.Status != rpc.STATUS_SERVING {
+		writeJson(w, http.StatusServiceUnavailable, health)
+		return
+	}
+	writeJson(w, http.StatusOK, health)
+}
+
+// Writes the value as the JSON body of the response
+func writeJson(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false) // keep <, > and & of log lines readable
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("Failed to write HTTP response: %v", err) // ex: the client went away
+	}
+}
@@ -0,0 +1,90 @@
+package distributed_engine
+
+import (
+	"container/heap"
+	"cs425_mp1/internal/grep"
+	"strings"
+	"time"
+)
+
+// A line of a machine's grep output along with the timestamp it is ordered by when merging
+type mergeLine struct {
+	text      string
+	timestamp time.Time
+	source    int // index of the output the line came from
+}
+
+// Reads the lines of one machine's grep output in order, giving each its timestamp
+type outputLineReader struct {
+	lines         []string
+	next          int
+	prefix        string // "<machine>:<file>: " printed before each line
+	lastTimestamp time.Time
+}
+
+// Returns the next line of the output and false once there are none left. Lines w/o a timestamp
+// (ex: the rest of a multi-line message) take the timestamp of the line above them so they stay below it
+func (r *outputLineReader) nextLine(executor *grep.Executor, source int) (mergeLine, bool) {
+	if r.next >= len(r.lines) {
+		return mergeLine{}, false
+	}
+	text := r.lines[r.next]
+	r.next++
+	if ts, ok := executor.LineTimestamp(text); ok {
+		r.lastTimestamp = ts
+	}
+	return mergeLine{text: r.prefix + text, timestamp: r.lastTimestamp, source: source}, true
+}
+
+// Min heap of the next line of each output, ordered by timestamp and then by the order of the outputs
+type mergeHeap []mergeLine
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if !h[i].timestamp.Equal(h[j].timestamp) {
+		return h[i].timestamp.Before(h[j].timestamp)
+	}
+	return h[i].source < h[j].source
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeLine)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	line := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return line
+}
+
+// MergeOutputsByTime k-way merges the output lines of all machines into a single output in time order,
+// prefixing each line with the machine and file it came from, ex: "<machine>:vm1.log: <line>".
+// Each machine's output is already in time order since grep outputs lines in the order of the log file,
+// so only the next line of each output needs to be compared. Timestamps are parsed with the executor's log
+// format, so all machines are assumed to have the same one. Lines w/o a timestamp stay below the line above
+// them, and outputs w/o any timestamps (ex: counts of -c) come first. Lines with equal timestamps are kept
+// in the order of the outputs
+func MergeOutputsByTime(outputs []grep.GrepOutput, executor *grep.Executor) string {
+	readers := make([]*outputLineReader, len(outputs))
+	h := make(mergeHeap, 0, len(outputs))
+	for i, gOut := range outputs {
+		lines := strings.Split(strings.TrimSuffix(gOut.Output, "\n"), "\n")
+		if gOut.Output == "" {
+			lines = nil
+		}
+		readers[i] = &outputLineReader{lines: lines, prefix: gOut.Machine + ":" + gOut.Filename + ": "}
+		if line, ok := readers[i].nextLine(executor, i); ok {
+			h = append(h, line)
+		}
+	}
+	heap.Init(&h)
+
+	var merged strings.Builder
+	for h.Len() > 0 {
+		line := heap.Pop(&h).(mergeLine)
+		merged.WriteString(line.text)
+		merged.WriteByte('\n')
+		if next, ok := readers[line.source].nextLine(executor, line.source); ok {
+			heap.Push(&h, next)
+		}
+	}
+	return merged.String()
+}
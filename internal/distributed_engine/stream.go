@@ -0,0 +1,157 @@
+package distributed_engine
+
+import (
+	"context"
+	"cs425_mp1/internal/grep"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default time b/w two polls of the machines for new matching lines when following a query
+const DEFAULT_FOLLOW_INTERVAL = time.Second
+
+// Shortest time b/w two polls a client can ask for, so that a client can't keep every machine grepping
+const MIN_FOLLOW_INTERVAL = 100 * time.Millisecond
+
+// Max number of new lines fetched from all machines in one poll. If there are more, they are fetched right away
+const FOLLOW_BATCH_SIZE = 1000
+
+// Event sent to the client following a query: a new matching line ("match"), or the error of a machine whose
+// query failed ("error"), tagged by the machine and the log file it came from
+type StreamEvent struct {
+	Machine  string
+	Filename string
+	Line     string `json:",omitempty"`
+	Error    string `json:",omitempty"`
+}
+
+/*
+Follow the query on all machines like "tail -f | grep": polls the machines every interval for the lines matching the
+query that were appended to their logs since the last poll, and calls send with each of them. Lines already in the
+logs when Follow() is called are not sent.
+
+Polls are queries with --max-results and a --cursor of the lines already sent by each machine (see grep.Page), so each
+machine only greps the tail of its log appended since (its output of the query is cached) and only sends back the new
+lines. A machine whose query fails is sent once as an "error" event until its query succeeds again. A machine whose
+log has fewer lines than were sent (it was rotated or truncated) is followed again from the start of its new log.
+
+Returns when ctx is done, or with the error of send. Returns an error right away if the query can't be followed, i.e.
+it outputs counts or file names instead of lines (ex: -c, -l or --count-by)
+*/
+func (dpe *DistributedGrepEngine) Follow(ctx context.Context, gquery *grep.GrepQuery, interval time.Duration, send func(event string, data StreamEvent) error) error {
+	offsets := make(map[string]int)       // machine -> number of its output lines already sent or skipped
+	lastErrors := make(map[string]string) // machine -> error already sent
+	for {
+		pollQuery, err := followQuery(gquery, offsets)
+		if err != nil {
+			return err
+		}
+		result := dpe.ExecuteAll(pollQuery, nil)
+
+		moreLines := false
+		for _, gOut := range result.Outputs {
+			if gOut.Failed() {
+				if lastErrors[gOut.Machine] != gOut.Error {
+					lastErrors[gOut.Machine] = gOut.Error
+					if err = send("error", StreamEvent{Machine: gOut.Machine, Filename: gOut.Filename, Error: gOut.Error}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			delete(lastErrors, gOut.Machine)
+			if offset, ok := offsets[gOut.Machine]; ok && gOut.Offset < offset {
+				// the machine has fewer lines than were already sent, i.e. its log was rotated or truncated, so
+				// all of its lines are new: poll it again from its first line
+				offsets[gOut.Machine] = 0
+				moreLines = true
+				continue
+			}
+
+			if gOut.NumLines > 0 {
+				for _, line := range strings.Split(strings.TrimSuffix(gOut.Output, "\n"), "\n") {
+					if err = send("match", StreamEvent{Machine: gOut.Machine, Filename: gOut.Filename, Line: line}); err != nil {
+						return err
+					}
+				}
+			}
+			// a machine polled for the first time skips its lines to the end (see grep.Page.Apply())
+			offsets[gOut.Machine] = gOut.Offset + gOut.NumLines
+			moreLines = moreLines || gOut.Truncated
+		}
+
+		if moreLines { // some lines didn't fit in the batch, so get them w/o waiting
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Returns the query of the next poll of the query being followed, given the number of output lines already sent by
+// each machine
+func followQuery(gquery *grep.GrepQuery, offsets map[string]int) (*grep.GrepQuery, error) {
+	cursor := make([]string, 0, len(offsets))
+	for machine, offset := range offsets {
+		cursor = append(cursor, fmt.Sprintf("%s:%d", machine, offset))
+	}
+	sort.Strings(cursor) // same cursor for the same offsets, so the same query isn't packaged differently
+
+	args := gquery.WithoutPagination().CmdArgs
+	pollArgs := []string{args[0], "--max-results=" + strconv.Itoa(FOLLOW_BATCH_SIZE), "--cursor=" + strings.Join(cursor, ",")}
+	return grep.CreateGrepQueryFromArgs(append(pollArgs, args[1:]...))
+}
+
+// GET /stream?q=<query>[&interval=<duration>]: follows the query (see Follow()) as server-sent events, ex:
+//
+//	event: match
+//	data: {"Machine":"fa23-cs425-1901.cs.illinois.edu","Filename":"vm1.log","Line":"ERROR: Disk full"}
+func (dpe *DistributedGrepEngine) handleHttpStream(w http.ResponseWriter, r *http.Request) {
+	input := r.URL.Query().Get("q")
+	if input == "" {
+		writeJson(w, http.StatusBadRequest, HttpError{Error: "Missing query, ex: ?q=grep ERROR"})
+		return
+	}
+	gQuery, err := grep.CreateGrepQueryFromInput(input)
+	if err == nil {
+		_, err = followQuery(gQuery, nil) // the query must output lines
+	}
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, HttpError{Error: "Invalid query: " + err.Error()})
+		return
+	}
+	interval := DEFAULT_FOLLOW_INTERVAL
+	if intervalParam := r.URL.Query().Get("interval"); intervalParam != "" {
+		if interval, err = time.ParseDuration(intervalParam); err != nil || interval < MIN_FOLLOW_INTERVAL {
+			writeJson(w, http.StatusBadRequest, HttpError{Error: fmt.Sprintf("Invalid interval, must be at least %v", MIN_FOLLOW_INTERVAL)})
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJson(w, http.StatusInternalServerError, HttpError{Error: "Streaming is not supported"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	_ = dpe.Follow(r.Context(), gQuery, interval, func(event string, data StreamEvent) error {
+		dataBytes, _ := json.Marshal(data) // can't fail for strings
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, dataBytes); err != nil {
+			return err // client went away
+		}
+		flusher.Flush()
+		return nil
+	})
+}
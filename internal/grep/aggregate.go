@@ -0,0 +1,237 @@
+package grep
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Layout of the start time of a time bucket. Sorts in time order as a string
+const TIME_BUCKET_LAYOUT = "2006-01-02 15:04:05"
+
+// Value of a group key for lines that don't have the field (or a timestamp for time buckets)
+const MISSING_KEY_VALUE = "(none)"
+
+// Max width of the bars drawn next to the counts of an aggregate
+const MAX_BAR_WIDTH = 40
+
+// Number of matching lines in one group of an aggregate query, ex: Keys = ["2023-09-06 22:52:00", "ERROR"].
+// Keys has one value per key of the query's --count-by, in the same order
+type AggregateCount struct {
+	Keys  []string
+	Count int
+}
+
+// A key lines are grouped by, ex: "level", "machine" or "time/1m"
+type aggregateKey struct {
+	name   string        // as given in --count-by, ex: "time/1m"
+	field  string        // parsed field, or "machine" for the machine the line came from
+	bucket time.Duration // > 0 if the key is the timestamp truncated to buckets of this size
+}
+
+// Aggregate query given with --count-by and --top
+type aggregateSpec struct {
+	keys []aggregateKey
+	top  int // only keep the top groups by count. 0 = keep all of them
+}
+
+// Returns the aggregate spec of the query, and false if it is not an aggregate query
+func (q *GrepQuery) aggregateSpec() (*aggregateSpec, bool, error) {
+	countBy, hasCountBy := q.EngineOption("count-by")
+	top, hasTop := q.EngineOption("top")
+	if !hasCountBy {
+		if hasTop {
+			return nil, false, fmt.Errorf("Invalid input! --top must be combined with --count-by, ex: --count-by msg --top 10")
+		}
+		return nil, false, nil
+	}
+
+	spec := &aggregateSpec{}
+	for _, name := range strings.Split(countBy, ",") {
+		name = strings.TrimSpace(name)
+		key := aggregateKey{name: name, field: name}
+		if field, bucket, isBucket := strings.Cut(name, "/"); isBucket {
+			duration, err := time.ParseDuration(bucket)
+			if field != "time" || err != nil || duration <= 0 {
+				return nil, false, fmt.Errorf("Invalid input! Time buckets must be like time/1m, but got %q", name)
+			}
+			key.field, key.bucket = field, duration
+		}
+		if key.field == "" {
+			return nil, false, fmt.Errorf("Invalid input! --count-by must be a comma separated list of fields, ex: level,machine")
+		}
+		spec.keys = append(spec.keys, key)
+	}
+	if hasTop {
+		n, err := strconv.Atoi(top)
+		if err != nil || n <= 0 {
+			return nil, false, fmt.Errorf("Invalid input! --top must be a positive number, but got %q", top)
+		}
+		spec.top = n
+	}
+	return spec, true, nil
+}
+
+// Replaces the output lines with the number of lines in each group of the aggregate query, so only the
+// counts are sent back instead of the lines. The "machine" key is left empty since it is filled in once
+// the outputs of all machines are combined (see CombineAggregates())
+func (e *Executor) aggregate(gOut *GrepOutput, spec *aggregateSpec) {
+	counts := make(map[string]*AggregateCount)
+	numLines := 0
+	for _, line := range strings.SplitAfter(gOut.Output, "\n") {
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			continue
+		}
+		numLines++
+
+		fields, _ := e.logParser.Parse(line)
+		keys := make([]string, len(spec.keys))
+		for i, key := range spec.keys {
+			switch {
+			case key.bucket > 0:
+				keys[i] = MISSING_KEY_VALUE
+				if ts, ok := e.LineTimestamp(line); ok {
+					keys[i] = ts.Truncate(key.bucket).Format(TIME_BUCKET_LAYOUT)
+				}
+			case key.field == "machine":
+				keys[i] = ""
+			default:
+				value, ok := fields[key.field]
+				if !ok {
+					value = MISSING_KEY_VALUE
+				}
+				keys[i] = value
+			}
+		}
+
+		groupKey := strings.Join(keys, "\x00")
+		if count, ok := counts[groupKey]; ok {
+			count.Count++
+		} else {
+			counts[groupKey] = &AggregateCount{Keys: keys, Count: 1}
+		}
+	}
+
+	gOut.Aggregates = make([]AggregateCount, 0, len(counts))
+	for _, count := range counts {
+		gOut.Aggregates = append(gOut.Aggregates, *count)
+	}
+	sortAggregatesByKeys(gOut.Aggregates)
+	gOut.Output = ""
+	gOut.NumLines = numLines
+	gOut.MatchCount = numLines
+	gOut.Records = nil
+}
+
+// Returns the names of the keys of the query's --count-by, or nil if it is not an aggregate query
+func (q *GrepQuery) AggregateKeys() []string {
+	spec, ok, err := q.aggregateSpec()
+	if err != nil || !ok {
+		return nil
+	}
+	names := make([]string, len(spec.keys))
+	for i, key := range spec.keys {
+		names[i] = key.name
+	}
+	return names
+}
+
+// CombineAggregates combines the counts of the aggregate query from each machine's output into the
+// counts over all machines, filling in the "machine" key with the machine each output came from.
+// The groups are sorted by their keys (so time buckets are in time order), unless the query has
+// --top N, in which case only the N groups with the highest counts are kept, highest first
+func CombineAggregates(q *GrepQuery, outputs []GrepOutput) []AggregateCount {
+	spec, ok, err := q.aggregateSpec()
+	if err != nil || !ok {
+		return nil
+	}
+
+	counts := make(map[string]*AggregateCount)
+	for _, gOut := range outputs {
+		for _, count := range gOut.Aggregates {
+			keys := append([]string{}, count.Keys...)
+			for i, key := range spec.keys {
+				if key.field == "machine" && key.bucket == 0 && i < len(keys) {
+					keys[i] = gOut.Machine
+				}
+			}
+			groupKey := strings.Join(keys, "\x00")
+			if combined, ok := counts[groupKey]; ok {
+				combined.Count += count.Count
+			} else {
+				counts[groupKey] = &AggregateCount{Keys: keys, Count: count.Count}
+			}
+		}
+	}
+
+	combined := make([]AggregateCount, 0, len(counts))
+	for _, count := range counts {
+		combined = append(combined, *count)
+	}
+	sortAggregatesByKeys(combined)
+	if spec.top > 0 {
+		sort.SliceStable(combined, func(i, j int) bool { return combined[i].Count > combined[j].Count })
+		if len(combined) > spec.top {
+			combined = combined[:spec.top]
+		}
+	}
+	return combined
+}
+
+// Returns the counts of both lists added together. Used to merge the counts of a query over the
+// cached prefix of a log file with its counts over the appended tail
+func mergeAggregates(cached []AggregateCount, tail []AggregateCount) []AggregateCount {
+	counts := make(map[string]int, len(cached)+len(tail))
+	keysOf := make(map[string][]string, len(cached)+len(tail))
+	for _, list := range [][]AggregateCount{cached, tail} {
+		for _, count := range list {
+			groupKey := strings.Join(count.Keys, "\x00")
+			counts[groupKey] += count.Count
+			keysOf[groupKey] = count.Keys
+		}
+	}
+
+	merged := make([]AggregateCount, 0, len(counts))
+	for groupKey, count := range counts {
+		merged = append(merged, AggregateCount{Keys: keysOf[groupKey], Count: count})
+	}
+	sortAggregatesByKeys(merged)
+	return merged
+}
+
+func sortAggregatesByKeys(counts []AggregateCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		return strings.Join(counts[i].Keys, "\x00") < strings.Join(counts[j].Keys, "\x00")
+	})
+}
+
+// FormatAggregates formats the counts as a table with a column per key, the count and a bar
+// proportional to the count, ex:
+//
+//	time/1m              level  count
+//	2023-09-06 22:52:00  ERROR  12     ########
+func FormatAggregates(keyNames []string, counts []AggregateCount) string {
+	maxCount := 0
+	for _, count := range counts {
+		if count.Count > maxCount {
+			maxCount = count.Count
+		}
+	}
+
+	var table strings.Builder
+	writer := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(writer, "%s\tcount\t\n", strings.Join(keyNames, "\t"))
+	for _, count := range counts {
+		barWidth := 0
+		if maxCount > 0 {
+			barWidth = (count.Count*MAX_BAR_WIDTH + maxCount - 1) / maxCount
+		}
+		_, _ = fmt.Fprintf(writer, "%s\t%d\t%s\n", strings.Join(count.Keys, "\t"), count.Count, strings.Repeat("#", barWidth))
+	}
+	_ = writer.Flush()
+	return table.String()
+}
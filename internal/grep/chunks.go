@@ -0,0 +1,139 @@
+package grep
+
+import (
+	"bytes"
+	"cs425_mp1/internal/index"
+	"io"
+	"os"
+	"sync"
+)
+
+// Default number of bytes of the log file grepped by each worker at a time when scanning a file in parallel
+const DEFAULT_CHUNK_SIZE = 16 << 20
+
+// Size of the pieces read when looking for the end of the line a chunk ends in
+const LINE_END_SEARCH_SIZE = 4096
+
+// Sets the number of workers that scan chunks of chunkSize bytes (DEFAULT_CHUNK_SIZE if <= 0) of a log file
+// in parallel, so that queries on large logs use all the cores. With 1 worker the file is scanned in one go.
+// Only queries evaluated in this process are split (see scansInProcess())
+func (e *Executor) SetParallelism(workers int, chunkSize int64) {
+	if workers < 1 {
+		workers = 1
+	}
+	if chunkSize <= 0 {
+		chunkSize = DEFAULT_CHUNK_SIZE
+	}
+	e.workers, e.chunkSize = workers, chunkSize
+}
+
+// Returns true if the query on ranges of a file with this total size is split into chunks scanned in parallel
+func (e *Executor) scansInParallel(q *GrepQuery, size int64) bool {
+	return e.workers > 1 && size > e.chunkSize && q.scansInProcess() && q.supportsSplitting()
+}
+
+// Returns true if the lines of the query are selected by the engine instead of grep, i.e. with --expr, so that its
+// chunks are scanned by goroutines. Queries run by grep aren't split, since every chunk would start its own grep
+// process and have the chunk piped to it instead of grep reading the file itself
+func (q *GrepQuery) scansInProcess() bool {
+	_, hasExpr := q.EngineOption("expr")
+	return hasExpr
+}
+
+// Scans each chunk of the file with a pool of workers and merges their outputs in file order.
+// If a chunk fails, its output is returned
+func (e *Executor) executeChunks(q *GrepQuery, filename string, file *os.File, chunks [][]index.Range) *GrepOutput {
+	outputs := make([]*GrepOutput, len(chunks))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < e.workers && w < len(chunks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				outputs[i] = e.execute(q, filename, rangesReader(file, chunks[i]))
+			}
+		}()
+	}
+	for i := range chunks {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, gOut := range outputs {
+		if gOut.Failed() {
+			return gOut
+		}
+	}
+	return q.mergeOutputs(outputs)
+}
+
+// Returns a reader of the ranges of the file one after another
+func rangesReader(file *os.File, ranges []index.Range) io.Reader {
+	if len(ranges) == 1 {
+		return io.NewSectionReader(file, ranges[0].Start, ranges[0].End-ranges[0].Start)
+	}
+	readers := make([]io.Reader, len(ranges))
+	for i, r := range ranges {
+		readers[i] = io.NewSectionReader(file, r.Start, r.End-r.Start)
+	}
+	return io.MultiReader(readers...)
+}
+
+// Returns the total number of bytes in the ranges
+func rangesSize(ranges []index.Range) int64 {
+	size := int64(0)
+	for _, r := range ranges {
+		size += r.End - r.Start
+	}
+	return size
+}
+
+// Splits the ranges of the file (which start and end on line boundaries) into chunks of about chunkSize bytes
+// that end on line boundaries. A chunk may be made up of several ranges, and a line is never split between chunks
+func splitIntoChunks(file *os.File, ranges []index.Range, chunkSize int64) [][]index.Range {
+	chunks := make([][]index.Range, 0)
+	current := make([]index.Range, 0)
+	currentSize := int64(0)
+	for _, r := range ranges {
+		for r.Start < r.End {
+			piece := r
+			if piece.End-piece.Start > chunkSize-currentSize {
+				piece.End = nextLineStart(file, piece.Start+chunkSize-currentSize, r.End)
+			}
+			current = append(current, piece)
+			currentSize += piece.End - piece.Start
+			r.Start = piece.End
+			if currentSize >= chunkSize {
+				chunks = append(chunks, current)
+				current = make([]index.Range, 0)
+				currentSize = 0
+			}
+		}
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// Returns the offset of the start of the line after the one offset is in, or limit if there is none before it
+func nextLineStart(file *os.File, offset int64, limit int64) int64 {
+	buff := make([]byte, LINE_END_SEARCH_SIZE)
+	for offset < limit {
+		size := int64(len(buff))
+		if limit-offset < size {
+			size = limit - offset
+		}
+		n, err := file.ReadAt(buff[:size], offset)
+		if i := bytes.IndexByte(buff[:n], '\n'); i != -1 {
+			return offset + int64(i) + 1
+		}
+		if err != nil {
+			break
+		}
+		offset += int64(n)
+	}
+	return limit
+}
@@ -0,0 +1,75 @@
+package grep
+
+import (
+	"strings"
+)
+
+// Line grep prints between groups of context lines that aren't next to each other
+const CONTEXT_SEPARATOR = "--"
+
+// Returns true if the query prints context lines around the matching lines (-A, -B or -C)
+func (q *GrepQuery) hasContext() bool {
+	return q.hasOption("-A") || q.hasOption("-B") || q.hasOption("-C")
+}
+
+// Returns true if the output lines of the query aren't one per matching line, so grep is run with line numbers
+// to count the matching lines: context lines (-A, -B, -C) or a line per match (-o). With -c, grep only prints
+// the count of matching lines whatever the other options, so it is parsed as is
+func (q *GrepQuery) needsLineNumbers() bool {
+	return !q.hasOption("-c") && (q.hasContext() || q.hasOption("-o"))
+}
+
+// Returns the grep args to tell which output lines are matching lines: with -n, since grep then ends the
+// line number of matching lines with ":" and of context lines with "-"
+func prepareArgsForLineNumbers(grepArgs []string) []string {
+	return append([]string{grepArgs[0], "-n"}, grepArgs[1:]...)
+}
+
+// Counts the matching lines and the context lines of the output of grep run with -n, and removes the line
+// numbers again unless the user asked for them (keepLineNumbers). Separators between groups of lines count
+// as neither, and the matches of -o on the same line count as a single matching line. filenamePrefix is the
+// filename grep printed with -H. Lines that can't be parsed are counted as matching lines and kept as is
+func splitNumberedOutput(output string, filenamePrefix string, keepLineNumbers bool) (string, int, int) {
+	var result strings.Builder
+	matches, contextLines := 0, 0
+	lastMatchLineNum := "" // grep prints lines in order, so the matches of a line are next to each other
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.TrimSuffix(line, "\n") == CONTEXT_SEPARATOR {
+			result.WriteString(line)
+			continue
+		}
+
+		prefixEnd := 0 // end of the filename prefix, including the ":" or "-" after it
+		if filenamePrefix != "" && strings.HasPrefix(line, filenamePrefix) && len(line) > len(filenamePrefix) {
+			prefixEnd = len(filenamePrefix) + 1
+		}
+		digitsEnd := prefixEnd
+		for digitsEnd < len(line) && line[digitsEnd] >= '0' && line[digitsEnd] <= '9' {
+			digitsEnd++
+		}
+		if digitsEnd == prefixEnd || digitsEnd >= len(line) || (line[digitsEnd] != ':' && line[digitsEnd] != '-') {
+			matches++
+			result.WriteString(line)
+			continue
+		}
+
+		if line[digitsEnd] == ':' {
+			if lineNum := line[prefixEnd:digitsEnd]; lineNum != lastMatchLineNum {
+				matches++
+				lastMatchLineNum = lineNum
+			}
+		} else {
+			contextLines++
+		}
+		if keepLineNumbers {
+			result.WriteString(line)
+		} else {
+			result.WriteString(line[:prefixEnd])
+			result.WriteString(line[digitsEnd+1:])
+		}
+	}
+	return result.String(), matches, contextLines
+}
@@ -0,0 +1,331 @@
+package grep
+
+import (
+	"cs425_mp1/internal/index"
+	"cs425_mp1/internal/logformat"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Executor executes grep queries on this machine's log file with this machine's settings,
+// ex: the format of its log lines. Queries are sent to every machine as is, and each machine
+// executes them with its own Executor
+type Executor struct {
+	logParser logformat.Parser // parses log lines into fields for --where filters
+	index     *index.LogIndex  // index of the log file used to skip parts of it w/o matches. nil if there is none
+	workers   int              // number of chunks of a log file scanned in parallel (see SetParallelism())
+	chunkSize int64
+}
+
+// NewExecutor creates an Executor that parses log lines with logParser.
+// If logParser is nil, lines are parsed in the default log format (see logformat.DEFAULT_PATTERN)
+func NewExecutor(logParser logformat.Parser) *Executor {
+	if logParser == nil {
+		var err error
+		logParser, err = logformat.NewParser("default")
+		if err != nil {
+			log.Fatalf("Failed to create default log parser: %v", err)
+		}
+	}
+	return &Executor{logParser: logParser, workers: 1, chunkSize: DEFAULT_CHUNK_SIZE}
+}
+
+// Exit statuses of an execution, the same as grep's
+const (
+	EXIT_MATCH    = 0 // some lines matched
+	EXIT_NO_MATCH = 1 // no lines matched
+	EXIT_ERROR    = 2 // the query couldn't be executed, ex: invalid regex or missing log file
+)
+
+// Sets the index of the log file, which queries on that file consult to only grep the parts of it that may
+// have matches (see index.LogIndex). The index is updated with the lines appended to the file before each query
+func (e *Executor) SetIndex(idx *index.LogIndex) {
+	e.index = idx
+}
+
+// Returns the stats of the index, and false if there is none
+func (e *Executor) IndexStats() (index.Stats, bool) {
+	if e.index == nil {
+		return index.Stats{}, false
+	}
+	return e.index.Stats(), true
+}
+
+// Returns the literals to look up in the index for the query on the file, and false if the index can't be used
+func (e *Executor) indexLiterals(q *GrepQuery, filename string) ([]string, bool) {
+	if e.index == nil || e.index.Filename() != filename {
+		return nil, false
+	}
+	return q.indexLiterals()
+}
+
+// Executes the grep query on the whole file provided, and returns a GrepOutput object
+func (e *Executor) Execute(q *GrepQuery, filename string) *GrepOutput {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return errorOutput(filename, err.Error())
+	}
+	_, hasTimeRange, _ := q.timeRange()
+	_, useIndex := e.indexLiterals(q, filename)
+	// only the lines in the time range, the blocks of the index or each chunk are scanned
+	if hasTimeRange || useIndex || e.scansInParallel(q, info.Size()) {
+		return e.ExecuteRange(q, filename, 0, info.Size())
+	}
+	return e.execute(q, filename, nil)
+}
+
+// Executes the grep query on the byte range [start, end) of the file provided, and returns a GrepOutput object.
+// The range is fed to grep through stdin (labelled with the file's base name), so the caller should
+// make sure start and end fall on line boundaries. Used by the engine to grep only the newly appended
+// tail of a log file when refreshing a cached result. If the query has --since or --until, the range is
+// first narrowed down to the lines in the time range (see narrowToTimeRange()), and then to the blocks
+// the index says may have matches if there is an index of the file. Large ranges are split into chunks
+// scanned in parallel if the executor has more than 1 worker and the query has --expr (see SetParallelism())
+func (e *Executor) ExecuteRange(q *GrepQuery, filename string, start int64, end int64) *GrepOutput {
+	startTime := time.Now()
+	file, err := os.Open(filename)
+	if err != nil {
+		return errorOutput(filename, err.Error())
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	tr, hasTimeRange, err := q.timeRange()
+	if err != nil { // queries are validated when created, so only a corrupted query gets here
+		return errorOutput(filename, err.Error())
+	}
+	if hasTimeRange {
+		start, end = e.narrowToTimeRange(file, start, end, tr)
+	}
+
+	ranges := []index.Range{{Start: start, End: end}}
+	if literals, ok := e.indexLiterals(q, filename); ok {
+		_ = e.index.Update() // if it fails, the lines it didn't index are still grepped
+		ranges = e.index.CandidateRanges(literals, start, end)
+	}
+
+	var gOut *GrepOutput
+	if e.scansInParallel(q, rangesSize(ranges)) {
+		gOut = e.executeChunks(q, filename, file, splitIntoChunks(file, ranges, e.chunkSize))
+	} else {
+		gOut = e.execute(q, filename, rangesReader(file, ranges))
+	}
+	gOut.ExecutionTime = time.Now().Sub(startTime) // include the time spent searching for the time range
+	return gOut
+}
+
+// Helper function to run grep on the input, or on the file if input is nil, and apply the engine options
+func (e *Executor) execute(q *GrepQuery, filename string, input io.Reader) *GrepOutput {
+	start := time.Now()
+	baseFileName := filepath.Base(filename)
+
+	where, hasWhere := q.EngineOption("where")
+	spec, hasAggregate, err := q.aggregateSpec()
+	if err != nil { // queries are validated when created, so only a corrupted query gets here
+		return errorOutput(filename, err.Error())
+	}
+	grepArgs := q.grepCmdArgs()
+	if hasWhere || hasAggregate {
+		grepArgs = prepareArgsForFilter(grepArgs)
+	} else if !hasPattern(grepArgs) { // ex: "grep --since 22:50" searches every line in the time range
+		grepArgs = append(grepArgs, "-e", "")
+	}
+	listMode := q.isListMode()
+	if listMode {
+		grepArgs = prepareArgsForListMode(grepArgs)
+	}
+	needsLineNumbers := q.needsLineNumbers()
+	if needsLineNumbers {
+		grepArgs = prepareArgsForLineNumbers(grepArgs)
+	}
+
+	expr, hasExpr, err := q.patternExpr()
+	if err != nil {
+		return errorOutput(filename, err.Error())
+	}
+	var outputStr, stderr string
+	var status int
+	if hasExpr { // lines are selected by the expression instead of grep, and filtered and counted below as usual
+		outputStr, status, stderr = runPatternExpr(expr, filename, input, q.hasOption("-c") && !hasWhere)
+	} else {
+		outputStr, status, stderr = runGrep(grepArgs, filename, input)
+	}
+	if status == EXIT_ERROR {
+		return errorOutput(filename, stderr)
+	}
+
+	gOut := &GrepOutput{Output: outputStr, Filename: baseFileName, NumLines: strings.Count(outputStr, "\n"), Stderr: stderr}
+	switch {
+	case listMode:
+		gOut.MatchCount = parseCount(outputStr)
+		gOut.Output = q.listModeOutput(gOut.MatchCount, grepDisplayName(filename, input != nil))
+		gOut.NumLines = strings.Count(gOut.Output, "\n")
+	case needsLineNumbers:
+		filenamePrefix := ""
+		if q.hasOption("-H") {
+			filenamePrefix = grepDisplayName(filename, input != nil)
+		}
+		gOut.Output, gOut.MatchCount, gOut.ContextLines = splitNumberedOutput(outputStr, filenamePrefix, q.hasOption("-n"))
+		gOut.NumLines = strings.Count(gOut.Output, "\n")
+	case q.hasOption("-c") && !hasWhere: // with --where, lines are counted after filtering
+		gOut.MatchCount = parseCount(outputStr)
+	default:
+		gOut.MatchCount = gOut.NumLines
+	}
+	if hasWhere {
+		filter, err := ParseFilter(where)
+		if err != nil { // queries are validated when created, so only a corrupted query gets here
+			return errorOutput(filename, err.Error())
+		}
+		e.applyFilter(gOut, filter, q.hasOption("-c"))
+	}
+	if hasAggregate {
+		e.aggregate(gOut, spec)
+	}
+
+	gOut.ExitStatus = EXIT_NO_MATCH
+	if gOut.MatchCount > 0 {
+		gOut.ExitStatus = EXIT_MATCH
+	}
+	gOut.ExecutionTime = time.Now().Sub(start)
+	return gOut
+}
+
+// Keeps only the output lines whose parsed fields match the filter, and stores them with their fields
+// in the output's records. Lines that can't be parsed in the log format never match.
+// If countOnly is set, the output is replaced by the number of matching lines like "grep -c" would
+func (e *Executor) applyFilter(gOut *GrepOutput, filter Filter, countOnly bool) {
+	records := make([]LogRecord, 0)
+	for _, line := range strings.SplitAfter(gOut.Output, "\n") {
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			continue
+		}
+		fields, ok := e.logParser.Parse(line)
+		if !ok {
+			continue
+		}
+		record := LogRecord{Line: line, Fields: fields}
+		if filter.Matches(&record) {
+			records = append(records, record)
+		}
+	}
+
+	if countOnly {
+		gOut.Output = strconv.Itoa(len(records)) + "\n"
+		gOut.NumLines = 1
+		gOut.MatchCount = len(records)
+		return
+	}
+
+	var output strings.Builder
+	for _, record := range records {
+		output.WriteString(record.Line)
+		output.WriteByte('\n')
+	}
+	gOut.Output = output.String()
+	gOut.NumLines = len(records)
+	gOut.MatchCount = len(records)
+	gOut.Records = records
+}
+
+// Returns the grep args to get the lines a filter is applied to: w/o "-c" since the lines are
+// counted after filtering, and with a pattern matching every line if there is no pattern
+func prepareArgsForFilter(grepArgs []string) []string {
+	args := withoutFlags(grepArgs, "c")
+	if !hasPattern(grepArgs) {
+		args = append(args, "-e", "")
+	}
+	return args
+}
+
+// Returns the grep args w/o the short flags given (ex: "lq"), whether they are given on their own, in a group
+// of flags (ex: "-icA2" -> "-iA2") or as their long option (ex: --count)
+func withoutFlags(grepArgs []string, flags string) []string {
+	args := []string{grepArgs[0]}
+	for i := 1; i < len(grepArgs); i++ {
+		arg := grepArgs[i]
+		if arg == "--" {
+			args = append(args, grepArgs[i:]...)
+			break
+		}
+
+		takesNextArg := false
+		if strings.HasPrefix(arg, "--") {
+			name, _, hasValue := strings.Cut(arg[2:], "=")
+			if short, ok := longToShortOptions[name]; ok && strings.Contains(flags, short) {
+				continue
+			}
+			takesNextArg = !hasValue && longOptionTakesValue(name)
+		} else if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			// only remove the flags before the first option that takes a value, since the rest is its value
+			flagsEnd := 1
+			for flagsEnd < len(arg) && strings.IndexByte(SHORT_OPTIONS_WITH_VALUE, arg[flagsEnd]) == -1 {
+				flagsEnd++
+			}
+			takesNextArg = flagsEnd == len(arg)-1
+			kept := strings.Map(func(c rune) rune {
+				if strings.ContainsRune(flags, c) {
+					return -1
+				}
+				return c
+			}, arg[1:flagsEnd])
+			arg = "-" + kept + arg[flagsEnd:]
+			if arg == "-" {
+				continue
+			}
+		}
+
+		args = append(args, arg)
+		if takesNextArg && i+1 < len(grepArgs) {
+			i++
+			args = append(args, grepArgs[i])
+		}
+	}
+	return args
+}
+
+// Runs grep with the args on the input, or on the file if input is nil.
+// Returns grep's output, its exit status, and what it printed to stderr (its error message if it failed)
+func runGrep(grepArgs []string, filename string, input io.Reader) (string, int, string) {
+	var cmd *exec.Cmd
+	if input == nil {
+		// make last arg the file to search -> which will be the log file for machine
+		cmdLineArgs := append(append([]string{}, grepArgs[1:]...), filename)
+		cmd = exec.Command(grepArgs[0], cmdLineArgs...)
+	} else {
+		// --label goes right after "grep" so it is still parsed as an option if the query uses "--"
+		cmdLineArgs := append([]string{"--label=" + filepath.Base(filename)}, grepArgs[1:]...)
+		cmd = exec.Command(grepArgs[0], cmdLineArgs...)
+		cmd.Stdin = input
+	}
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr // kept out of the output, so warnings don't end up in the matching lines
+	binaryOutput, err := cmd.Output()
+	warnings := strings.TrimSpace(stderr.String())
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == EXIT_NO_MATCH {
+			return string(binaryOutput), EXIT_NO_MATCH, warnings
+		}
+		if warnings == "" {
+			return "", EXIT_ERROR, err.Error()
+		}
+		return "", EXIT_ERROR, warnings
+	}
+	return string(binaryOutput), EXIT_MATCH, warnings
+}
+
+// Returns the output of an execution on the file that failed with the error message
+func errorOutput(filename string, errMsg string) *GrepOutput {
+	return &GrepOutput{Output: "", Filename: filepath.Base(filename), NumLines: 0, ExitStatus: EXIT_ERROR, Error: errMsg}
+}
@@ -0,0 +1,249 @@
+package grep
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A log line along with the fields it was parsed into by the machine's log format parser
+type LogRecord struct {
+	Line   string
+	Fields map[string]string
+}
+
+// Filter is a boolean expression evaluated on each log record, ex: `level=ERROR AND msg~"timeout"`
+type Filter interface {
+	Matches(record *LogRecord) bool
+}
+
+// ParseFilter parses a filter expression with the following syntax:
+//
+//	expr       := term (OR term)*
+//	term       := factor (AND factor)*
+//	factor     := NOT factor | "(" expr ")" | comparison
+//	comparison := field op value
+//
+// where op is one of "=" (equals), "!=" (not equals), "~" (matches regex) or "!~" (does not match regex),
+// and value is either a word w/o spaces or parentheses or a double quoted string (with \" and \\ escapes).
+// AND, OR and NOT can also be written in lower case. A record missing the field fails every comparison except "!="
+// and "!~". Returns an error describing the problem and its position if the expression is invalid
+func ParseFilter(expr string) (Filter, error) {
+	p := &filterParser{input: expr}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return filter, nil
+}
+
+type andFilter struct{ left, right Filter }
+type orFilter struct{ left, right Filter }
+type notFilter struct{ inner Filter }
+type comparisonFilter struct {
+	field string
+	op    string
+	value string
+	regex *regexp.Regexp // compiled value for "~" and "!~"
+}
+
+func (f *andFilter) Matches(record *LogRecord) bool {
+	return f.left.Matches(record) && f.right.Matches(record)
+}
+
+func (f *orFilter) Matches(record *LogRecord) bool {
+	return f.left.Matches(record) || f.right.Matches(record)
+}
+
+func (f *notFilter) Matches(record *LogRecord) bool {
+	return !f.inner.Matches(record)
+}
+
+func (f *comparisonFilter) Matches(record *LogRecord) bool {
+	value, ok := record.Fields[f.field]
+	switch f.op {
+	case "=":
+		return ok && value == f.value
+	case "!=":
+		return !ok || value != f.value
+	case "~":
+		return ok && f.regex.MatchString(value)
+	default: // "!~"
+		return !ok || !f.regex.MatchString(value)
+	}
+}
+
+// Recursive descent parser for filter expressions. Its helpers are shared with the parser of pattern expressions
+type filterParser struct {
+	input string
+	pos   int
+	kind  string // what is being parsed, for error messages. "" = filter
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (Filter, error) {
+	if p.acceptKeyword("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notFilter{inner}, nil
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, p.errorf("expected \")\"")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) && isFieldNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("expected a comparison like level=ERROR but the expression ended")
+		}
+		return nil, p.errorf("expected a field name")
+	}
+	field := p.input[start:p.pos]
+
+	var op string
+	for _, candidate := range []string{"!=", "!~", "=", "~"} {
+		if strings.HasPrefix(p.input[p.pos:], candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("expected one of =, !=, ~ or !~ after field %q", field)
+	}
+	p.pos += len(op)
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &comparisonFilter{field: field, op: op, value: value}
+	if op == "~" || op == "!~" {
+		comparison.regex, err = regexp.Compile(value)
+		if err != nil {
+			return nil, p.errorf("invalid regex %q: %v", value, err)
+		}
+	}
+	return comparison, nil
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	if p.pos < len(p.input) && p.input[p.pos] == '"' {
+		start := p.pos
+		var value strings.Builder
+		for p.pos++; p.pos < len(p.input); p.pos++ {
+			c := p.input[p.pos]
+			if c == '"' {
+				p.pos++
+				return value.String(), nil
+			}
+			if c == '\\' && p.pos+1 < len(p.input) && (p.input[p.pos+1] == '"' || p.input[p.pos+1] == '\\') {
+				p.pos++
+				c = p.input[p.pos]
+			}
+			value.WriteByte(c)
+		}
+		p.pos = start
+		return "", p.errorf("unterminated double quote")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune(" \t()", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if start == p.pos {
+		return "", p.errorf("expected a value")
+	}
+	return p.input[start:p.pos], nil
+}
+
+// Consumes the keyword if it is next in the input (in upper or lower case) and returns true
+func (p *filterParser) acceptKeyword(keyword string) bool {
+	p.skipSpaces()
+	end := p.pos + len(keyword)
+	if end > len(p.input) {
+		return false
+	}
+	word := p.input[p.pos:end]
+	if word != keyword && word != strings.ToLower(keyword) {
+		return false
+	}
+	if end < len(p.input) && isFieldNameChar(p.input[end]) { // ex: "ORDER=1" is a comparison, not OR
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *filterParser) skipSpaces() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	kind := p.kind
+	if kind == "" {
+		kind = "filter"
+	}
+	return fmt.Errorf("Invalid %s at position %d: %s", kind, p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func isFieldNameChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,439 @@
+package grep
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// One option of a grep command, ex: "-i" or "-A 2"
+// Long options that have a short equivalent are stored as the short option ("--count" -> "-c"),
+// and context options given as a bare number are stored as "-C" ("-3" -> "-C 3")
+type grepOption struct {
+	Name     string // "-" + letter for short options, "--" + name for long options w/o a short equivalent
+	Value    string
+	HasValue bool
+}
+
+// Short grep options that take a value, ex: "-e PATTERN" or "-A2"
+const SHORT_OPTIONS_WITH_VALUE = "efmABCdD"
+
+// Short grep options that can be given multiple times, with every value used (all other options: last one wins)
+const SHORT_OPTIONS_ACCUMULATE = "ef"
+
+// Long grep options that have a short equivalent
+var longToShortOptions = map[string]string{
+	"extended-regexp":       "E",
+	"fixed-strings":         "F",
+	"basic-regexp":          "G",
+	"perl-regexp":           "P",
+	"regexp":                "e",
+	"file":                  "f",
+	"ignore-case":           "i",
+	"invert-match":          "v",
+	"word-regexp":           "w",
+	"line-regexp":           "x",
+	"count":                 "c",
+	"files-without-match":   "L",
+	"files-with-matches":    "l",
+	"max-count":             "m",
+	"only-matching":         "o",
+	"quiet":                 "q",
+	"silent":                "q",
+	"no-messages":           "s",
+	"byte-offset":           "b",
+	"with-filename":         "H",
+	"no-filename":           "h",
+	"line-number":           "n",
+	"initial-tab":           "T",
+	"null":                  "Z",
+	"after-context":         "A",
+	"before-context":        "B",
+	"context":               "C",
+	"text":                  "a",
+	"directories":           "d",
+	"devices":               "D",
+	"recursive":             "r",
+	"dereference-recursive": "R",
+	"binary":                "U",
+	"null-data":             "z",
+}
+
+// Long options of a query that are handled by the engine instead of being passed to grep, ex: --where.
+// They stay in CmdArgs so that they are part of the packaged string and cache key, and are removed
+// from the args grep is run with. Value = true if the option takes a value
+var engineOptions = map[string]bool{
+	"where":                   true, // filter expression evaluated on the parsed fields of each line (see ParseFilter())
+	"since":                   true, // only search lines with a timestamp at or after this time (see logformat.ParseTimeBound())
+	"until":                   true, // only search lines with a timestamp at or before the end of this time, ex: the end of the day
+	"count-by":                true, // count the matching lines grouped by these comma separated keys (see aggregateSpec())
+	"top":                     true, // only keep the N groups of --count-by with the highest counts
+	"expr":                    true, // boolean expression over patterns that selects the lines instead of grep (see ParsePatternExpr())
+	"max-results":             true, // only return this many output lines in total, and per machine by default (see Page)
+	"max-results-per-machine": true, // only return this many output lines per machine, ex: to see lines of every machine
+	"cursor":                  true, // where the page of output lines starts on each machine, printed with the previous page
+}
+
+// Grep options that change the output lines of grep, so can't be combined with engine options that
+// work on the output lines. "-c" is not included since the engine counts the lines itself
+const LINE_FORMAT_OPTIONS = "nboqlLABCHTZz"
+
+// Returns true if the long grep option takes a value when it is not given with "="
+func longOptionTakesValue(name string) bool {
+	if takesValue, ok := engineOptions[name]; ok {
+		return takesValue
+	}
+	switch name {
+	case "label", "include", "exclude", "exclude-dir", "exclude-from", "binary-files", "group-separator":
+		return true
+	}
+	short, ok := longToShortOptions[name]
+	return ok && strings.Contains(SHORT_OPTIONS_WITH_VALUE, short)
+}
+
+// Splits the arguments of a grep command (w/o "grep") into its options and operands (patterns and files).
+// Combined short options are split up ("-ic" -> "-i", "-c"), and options with values are split from
+// their value ("-A2" -> "-A" "2")
+func parseGrepArgs(args []string) ([]grepOption, []string) {
+	opts := make([]grepOption, 0)
+	operands := make([]string, 0)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" { // everything after is a pattern or file operand
+			operands = append(operands, args[i+1:]...)
+			break
+		} else if strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			opt := grepOption{Name: "--" + name, Value: value, HasValue: hasValue}
+			if short, ok := longToShortOptions[name]; ok {
+				opt.Name = "-" + short
+			}
+			if !hasValue && longOptionTakesValue(name) && i+1 < len(args) {
+				i++
+				opt.Value, opt.HasValue = args[i], true
+			}
+			opts = append(opts, opt)
+		} else if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			for j := 1; j < len(arg); j++ {
+				c := arg[j]
+				if c >= '0' && c <= '9' { // "-NUM" is the same as "-C NUM"
+					end := j
+					for end < len(arg) && arg[end] >= '0' && arg[end] <= '9' {
+						end++
+					}
+					opts = append(opts, grepOption{Name: "-C", Value: arg[j:end], HasValue: true})
+					j = end - 1
+					continue
+				}
+
+				opt := grepOption{Name: "-" + string(c)}
+				if strings.IndexByte(SHORT_OPTIONS_WITH_VALUE, c) != -1 {
+					if j < len(arg)-1 { // value is the rest of this argument, ex: "-A2"
+						opt.Value, opt.HasValue = arg[j+1:], true
+					} else if i+1 < len(args) { // value is the next argument, ex: "-e" "ERROR"
+						i++
+						opt.Value, opt.HasValue = args[i], true
+					}
+					opts = append(opts, opt)
+					break
+				}
+				opts = append(opts, opt)
+			}
+		} else {
+			operands = append(operands, arg)
+		}
+	}
+
+	return opts, operands
+}
+
+// CanonicalizeArgs returns the canonical form of the command args of a grep query, so that equivalent
+// queries (ex: "grep -i -c ERROR", "grep -ci ERROR" and "grep --count -i ERROR") have the same canonical form:
+//   - combined short options are split up and options are given separately from their values
+//   - long options with a short equivalent are replaced by it, and "-NUM" by "-C NUM"
+//   - options are deduplicated (keeping the last value if the option has one, except for -e and -f which
+//     keep every value in order) and sorted, with flags before options that have values
+//   - options that are grep's defaults or that have no effect on a single file are removed ("-h" is, but it
+//     still overrides an earlier "-H")
+//
+// The args must start with the command ("grep"). Operands keep their order after the options
+func CanonicalizeArgs(cmdArgs []string) []string {
+	if len(cmdArgs) == 0 {
+		return cmdArgs
+	}
+	opts, operands := parseGrepArgs(cmdArgs[1:])
+
+	flags := make(map[string]bool)
+	valueOpts := make([]grepOption, 0)
+	ignoreCase := false
+	for _, opt := range opts {
+		switch {
+		case opt.Name == "-i" || opt.Name == "-y":
+			ignoreCase = true
+		case opt.Name == "--no-ignore-case":
+			ignoreCase = false
+		case opt.Name == "-h": // overrides any earlier -H, and filenames are never printed for a single file anyway
+			delete(flags, "-H")
+		case opt.HasValue:
+			if !isAccumulatingOption(opt.Name) { // last value wins, so drop any earlier value
+				valueOpts = removeOptions(valueOpts, opt.Name)
+			}
+			valueOpts = append(valueOpts, opt)
+		default:
+			flags[opt.Name] = true
+		}
+	}
+	if ignoreCase {
+		flags["-i"] = true
+	}
+	if flags["-G"] && !flags["-E"] && !flags["-F"] && !flags["-P"] { // basic regexp is the default matcher
+		delete(flags, "-G")
+	}
+
+	canonical := []string{cmdArgs[0]}
+	flagNames := make([]string, 0, len(flags))
+	for name := range flags {
+		flagNames = append(flagNames, name)
+	}
+	sort.Strings(flagNames)
+	canonical = append(canonical, flagNames...)
+
+	sort.SliceStable(valueOpts, func(i, j int) bool { return valueOpts[i].Name < valueOpts[j].Name })
+	for _, opt := range valueOpts {
+		if strings.HasPrefix(opt.Name, "--") {
+			canonical = append(canonical, opt.Name+"="+opt.Value)
+		} else {
+			canonical = append(canonical, opt.Name, opt.Value)
+		}
+	}
+
+	for _, operand := range operands {
+		if strings.HasPrefix(operand, "-") { // keep grep from reading the operands as options
+			canonical = append(canonical, "--")
+			break
+		}
+	}
+	return append(canonical, operands...)
+}
+
+// Returns true if the query uses the option given, ex: "-c". Long options with a short equivalent
+// must be given as the short option
+func (q *GrepQuery) hasOption(name string) bool {
+	for _, opt := range q.options() {
+		if opt.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns all the grep options used in the query
+func (q *GrepQuery) options() []grepOption {
+	if len(q.CmdArgs) < 2 {
+		return make([]grepOption, 0)
+	}
+	opts, _ := parseGrepArgs(q.CmdArgs[1:])
+	return opts
+}
+
+// Helper function for CanonicalizeArgs
+func isAccumulatingOption(name string) bool {
+	return len(name) == 2 && strings.IndexByte(SHORT_OPTIONS_ACCUMULATE, name[1]) != -1
+}
+
+// Helper function for CanonicalizeArgs - returns opts without any option named name
+func removeOptions(opts []grepOption, name string) []grepOption {
+	kept := opts[:0]
+	for _, opt := range opts {
+		if opt.Name != name {
+			kept = append(kept, opt)
+		}
+	}
+	return kept
+}
+
+// Returns the value of the engine option given (w/o dashes, ex: "where") and true if the query uses it.
+// If the option is given more than once, the last value wins
+func (q *GrepQuery) EngineOption(name string) (string, bool) {
+	value, found := "", false
+	for _, opt := range q.options() {
+		if opt.Name == "--"+name {
+			value, found = opt.Value, true
+		}
+	}
+	return value, found
+}
+
+// Returns the command args to run grep with, i.e. the query's command args w/o the engine options
+func (q *GrepQuery) grepCmdArgs() []string {
+	return q.argsWithout(engineOptions)
+}
+
+// Returns the query's command args w/o the engine options given (names w/o dashes, ex: "where")
+func (q *GrepQuery) argsWithout(options map[string]bool) []string {
+	args := []string{q.CmdArgs[0]}
+	for i := 1; i < len(q.CmdArgs); i++ {
+		arg := q.CmdArgs[i]
+		if arg == "--" {
+			return append(args, q.CmdArgs[i:]...)
+		}
+
+		takesNextArg := false
+		if strings.HasPrefix(arg, "--") {
+			name, _, hasValue := strings.Cut(arg[2:], "=")
+			takesNextArg = !hasValue && longOptionTakesValue(name)
+			if _, ok := options[name]; ok {
+				if takesNextArg {
+					i++
+				}
+				continue
+			}
+		} else if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			for j := 1; j < len(arg); j++ {
+				if strings.IndexByte(SHORT_OPTIONS_WITH_VALUE, arg[j]) != -1 {
+					takesNextArg = j == len(arg)-1
+					break
+				}
+			}
+		}
+
+		args = append(args, arg)
+		if takesNextArg && i+1 < len(q.CmdArgs) { // keep the value even if it looks like an engine option
+			i++
+			args = append(args, q.CmdArgs[i])
+		}
+	}
+	return args
+}
+
+// Returns true if the grep args have a pattern, either as an option (-e, -f) or as the first operand
+func hasPattern(cmdArgs []string) bool {
+	opts, operands := parseGrepArgs(cmdArgs[1:])
+	for _, opt := range opts {
+		if opt.Name == "-e" || opt.Name == "-f" {
+			return true
+		}
+	}
+	return len(operands) > 0
+}
+
+// Grep options that make grep read files other than the log file (-f: patterns from a file, -r/-R: search
+// directories recursively, -d/-D: read directories and devices). They are rejected since queries come from
+// clients over the network and must only ever search the log file
+const FILE_READING_OPTIONS = "frRdD"
+
+// Long grep options w/o a short equivalent that select the files grep reads
+var fileSelectionOptions = []string{"include", "exclude", "exclude-from", "exclude-dir"}
+
+// Checks that a grep command only searches the log file: it can't have file operands (the log file is given
+// to grep by the engine), so it has at most one pattern operand and none when the patterns are given with
+// -e, nor options that read other files. Abbreviated long options are rejected if they could be one of those
+func validateFileAccess(cmdArgs []string) error {
+	opts, operands := parseGrepArgs(cmdArgs[1:])
+	hasPatternOption := false
+	for _, opt := range opts {
+		if len(opt.Name) == 2 && strings.IndexByte(FILE_READING_OPTIONS, opt.Name[1]) != -1 {
+			return fmt.Errorf("Invalid input! %s can't be used since only the log file can be searched", opt.Name)
+		}
+		if strings.HasPrefix(opt.Name, "--") && isFileReadingLongOption(opt.Name[2:]) {
+			return fmt.Errorf("Invalid input! %s can't be used since only the log file can be searched", opt.Name)
+		}
+		hasPatternOption = hasPatternOption || opt.Name == "-e"
+	}
+
+	if hasPatternOption && len(operands) > 0 {
+		return fmt.Errorf("Invalid input! The patterns are given with -e, so %q can't be a file to search", operands[0])
+	} else if len(operands) > 1 {
+		return fmt.Errorf("Invalid input! Only one pattern can be given w/o -e, so %q can't be a file to search", operands[1])
+	}
+	return nil
+}
+
+// Helper function for validateFileAccess - returns true if name is, or is an abbreviation of, a long option
+// that reads files other than the log file
+func isFileReadingLongOption(name string) bool {
+	if name == "" {
+		return false
+	}
+	for long, short := range longToShortOptions {
+		if strings.HasPrefix(long, name) && strings.Contains(FILE_READING_OPTIONS, short) {
+			return true
+		}
+	}
+	for _, long := range fileSelectionOptions {
+		if strings.HasPrefix(long, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks that the engine options of a grep command are valid and can be combined with its grep options.
+// A grep command with a --where filter, an aggregate or a time range doesn't need a pattern, in which case
+// every line (in the time range) is searched
+func validateEngineOptions(cmdArgs []string) error {
+	q := &GrepQuery{CmdArgs: cmdArgs}
+
+	if _, _, err := q.timeRange(); err != nil {
+		return err
+	}
+	_, hasSince := q.EngineOption("since")
+	_, hasUntil := q.EngineOption("until")
+	if hasSince || hasUntil {
+		// only the lines in the time range are fed to grep, so line numbers and byte offsets would be off
+		for _, opt := range q.options() {
+			if opt.Name == "-n" || opt.Name == "-b" {
+				return fmt.Errorf("Invalid input! %s can't be combined with --since or --until", opt.Name)
+			}
+		}
+	}
+
+	_, hasExpr, err := q.patternExpr()
+	if err != nil {
+		return err
+	}
+	if hasExpr { // the expression is evaluated by the engine, which only supports some of grep's options
+		if hasPattern(q.grepCmdArgs()) {
+			return fmt.Errorf("Invalid input! --expr replaces the pattern, so the query can't have another one")
+		}
+		for _, opt := range q.options() {
+			if _, isEngineOption := engineOptions[strings.TrimPrefix(opt.Name, "--")]; isEngineOption {
+				continue
+			}
+			if len(opt.Name) != 2 || strings.IndexByte(EXPR_OPTIONS, opt.Name[1]) == -1 {
+				return fmt.Errorf("Invalid input! %s can't be combined with --expr - only -i, -F, -w and -c can", opt.Name)
+			}
+		}
+	}
+
+	_, hasAggregate, err := q.aggregateSpec()
+	if err != nil {
+		return err
+	}
+	if hasAggregate { // the matching lines are counted by the engine, so grep must output them as is
+		for _, opt := range q.options() {
+			if opt.Name == "-c" || (len(opt.Name) == 2 && strings.IndexByte(LINE_FORMAT_OPTIONS, opt.Name[1]) != -1) {
+				return fmt.Errorf("Invalid input! %s can't be combined with --count-by", opt.Name)
+			}
+		}
+	}
+
+	if _, _, err := q.Page(); err != nil {
+		return err
+	}
+
+	where, hasWhere := q.EngineOption("where")
+	if !hasWhere {
+		return nil
+	}
+	if _, err := ParseFilter(where); err != nil {
+		return err
+	}
+	for _, opt := range q.options() {
+		if len(opt.Name) == 2 && strings.IndexByte(LINE_FORMAT_OPTIONS, opt.Name[1]) != -1 {
+			return fmt.Errorf("Invalid input! %s can't be combined with --where", opt.Name)
+		}
+	}
+	return nil
+}
@@ -3,30 +3,99 @@ package grep
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"time"
 )
 
+// Output of a grep query on one machine's log file.
+// Outputs stored in the cache are shared between queries, so they must never be modified once cached.
+// Cache hits are returned as a copy with CacheHit and CacheLookupTime set instead
 type GrepOutput struct {
-	Output        string
-	Filename      string
-	NumLines      int
-	ExecutionTime time.Duration
+	Output          string
+	Filename        string
+	Machine         string           // host name of the machine whose log file was grepped
+	ExitStatus      int              // EXIT_MATCH, EXIT_NO_MATCH or EXIT_ERROR, like grep's exit status
+	Error           string           // why the execution failed if ExitStatus is EXIT_ERROR, ex: grep's error message
+	Stderr          string           // what grep printed to stderr when it didn't fail, ex: "grep: (standard input): binary file matches"
+	NumLines        int              // number of lines in Output, including context lines and separators
+	MatchCount      int              // number of matching lines in Output (in every page of it, with --max-results)
+	ContextLines    int              // number of context lines in Output, printed around the matching lines with -A, -B or -C
+	ExecutionTime   time.Duration    // time it took to execute the grep query that produced Output
+	CacheHit        bool             // true if Output was (at least partially) served from the cache
+	CacheLookupTime time.Duration    // time it took to serve Output from the cache. 0 if not a cache hit
+	Records         []LogRecord      // each output line with its parsed fields. Only set for queries with a --where filter
+	Aggregates      []AggregateCount // counts of the matching lines by group, instead of the lines. Only set for --count-by queries
+	Offset          int              // number of output lines returned in the previous pages, with --max-results (see Page)
+	Truncated       bool             // true if there are more output lines after the ones in Output, with --max-results
 }
 
-// Formats the contents of the GrepOutput as a string
+// Formats the contents of the GrepOutput as a string, under a header with the machine and file it came from
 func (g *GrepOutput) ToString() string {
-	//dashesWithFilename := "------------------------%s------------------------\n"
-	strFormat := "Filename: %s\nNum Lines: %d\nExecution Time: %dns\nOutput:\n%s\n"
+	dashesWithFilename := "------------------------%s------------------------\n"
+	strFormat := "Filename: %s\nNum Lines: %d\n%sExecution Time: %dns\n%sOutput:\n%s\n"
 	baseFileName := filepath.Base(g.Filename)
-	return fmt.Sprintf(strFormat, baseFileName, g.NumLines, g.ExecutionTime.Nanoseconds(), g.Output)
+	source := baseFileName
+	if g.Machine != "" {
+		source = g.Machine + ":" + baseFileName
+	}
+	countsStr := ""
+	if g.ContextLines > 0 || g.MatchCount != g.NumLines {
+		countsStr = fmt.Sprintf("Num Matches: %d\nNum Context Lines: %d\n", g.MatchCount, g.ContextLines)
+	}
+	if g.Failed() {
+		countsStr += fmt.Sprintf("Error: %s\n", g.Error)
+	} else if g.Stderr != "" {
+		countsStr += fmt.Sprintf("Stderr: %s\n", g.Stderr)
+	}
+	if g.Offset > 0 || g.Truncated {
+		countsStr += fmt.Sprintf("Showing Lines: %d-%d\n", g.Offset+1, g.Offset+g.NumLines)
+		if g.Truncated {
+			countsStr += "Truncated: more lines on the next page\n"
+		}
+	}
+	cacheStr := ""
+	if g.CacheHit {
+		cacheStr = fmt.Sprintf("Cache Hit: served in %dns\n", g.CacheLookupTime.Nanoseconds())
+	}
+	return fmt.Sprintf(dashesWithFilename, source) +
+		fmt.Sprintf(strFormat, baseFileName, g.NumLines, countsStr, g.ExecutionTime.Nanoseconds(), cacheStr, g.Output)
+}
+
+// Sets MatchCount and ExitStatus for outputs created before they existed (ex: sent by a peer running an older
+// version or persisted to the disk cache by one), in which every output line was counted as a matching line
+func (g *GrepOutput) FillMissingCounts() {
+	if g.MatchCount == 0 && g.ContextLines == 0 {
+		g.MatchCount = g.NumLines
+	}
+	if g.MatchCount == 0 && g.ExitStatus == EXIT_MATCH {
+		g.ExitStatus = EXIT_NO_MATCH
+	}
+}
+
+// Returns true if the execution failed, as opposed to finishing with or without matches
+func (g *GrepOutput) Failed() bool {
+	return g.ExitStatus == EXIT_ERROR
+}
+
+// Returns a copy of the cached output marking it as a cache hit served in lookupTime
+func (g *GrepOutput) AsCacheHit(lookupTime time.Duration) *GrepOutput {
+	hit := *g
+	hit.CacheHit = true
+	hit.CacheLookupTime = lookupTime
+	return &hit
 }
 
 // SerializeGrepOutput Serialize GrepOutput object into a byte array
-// The returned format is good to send over a TCP socket
+// The returned format is a JSON object, good to send over a TCP socket (see docs/protocol.md)
 // Returns nil if it failed to serialize
 func SerializeGrepOutput(grepOutput *GrepOutput) ([]byte, error) {
+	return json.Marshal(grepOutput)
+}
+
+// SerializeGrepOutputGob serializes the output with gob, the encoding used before JSON, for peers running an older version
+func SerializeGrepOutputGob(grepOutput *GrepOutput) ([]byte, error) {
 	binary_buff := new(bytes.Buffer)
 
 	encoder := gob.NewEncoder(binary_buff)
@@ -40,7 +109,7 @@ func SerializeGrepOutput(grepOutput *GrepOutput) ([]byte, error) {
 // DeserializeGrepOutput Deserializes the byte array into a GrepOutput object
 // Parameters:
 //
-//	data: deserialized version of a GrepOutput struct.
+//	data: serialized version of a GrepOutput struct, as JSON or with gob (by peers running an older version).
 //
 // Returns
 //
@@ -48,6 +117,13 @@ func SerializeGrepOutput(grepOutput *GrepOutput) ([]byte, error) {
 //	nil if it failed to deserialized
 func DeserializeGrepOutput(data []byte) (*GrepOutput, error) {
 	grepOutput := new(GrepOutput)
+	if !IsGobEncoded(data) {
+		if err := json.Unmarshal(data, grepOutput); err != nil {
+			return nil, err
+		}
+		return grepOutput, nil
+	}
+
 	byteBuffer := bytes.NewBuffer(data)
 	decoder := gob.NewDecoder(byteBuffer)
 
@@ -59,6 +135,24 @@ func DeserializeGrepOutput(data []byte) (*GrepOutput, error) {
 	return grepOutput, nil
 }
 
+// Returns the approximate number of bytes the output takes up in memory
+func (g *GrepOutput) SizeBytes() int64 {
+	size := int64(len(g.Output) + len(g.Filename) + len(g.Stderr))
+	for _, record := range g.Records {
+		size += int64(len(record.Line))
+		for key, value := range record.Fields {
+			size += int64(len(key) + len(value))
+		}
+	}
+	for _, count := range g.Aggregates {
+		size += 8
+		for _, key := range count.Keys {
+			size += int64(len(key))
+		}
+	}
+	return size
+}
+
 // Compares GrepOutput fields but does not compare execution time as that is not necessary for comparison in our cases
 func GrepOutputsAreEqual(grepOutput1 *GrepOutput, grepOutput2 *GrepOutput) bool {
 	return grepOutput1.Output == grepOutput2.Output && grepOutput1.NumLines == grepOutput2.NumLines && grepOutput1.Filename == grepOutput2.Filename
@@ -3,21 +3,24 @@ package grep
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
-	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
-	"time"
 )
 
 // Represents details of the grep query a user my type in. Contains all information of that
 // specific query, including any functions to execute the query or convert to a different form
 // GrepQuery is independent of the filename, therefore the cmdArgs field does not contain the filename
 type GrepQuery struct {
-	CmdArgs        []string // slice of the command line arguments (w/o the filename)
-	PackagedString string   // command args as one string concatenated by "-" b/w each arg
+	CmdArgs          []string // slice of the command line arguments (w/o the filename)
+	PackagedString   string   // command args packaged as one string (see PackageCmdArgs())
+	NormalizedString string   // canonical command args (see CanonicalizeArgs()) packaged the same way. Used as the cache key
+	AcceptEncoding   string   // compression the sender accepts for the output frame, ex: network.ENCODING_GZIP. "" = none
 }
 
+// Delimiter b/w the args of packaged strings in the legacy format, ex: "grep;-c;GET".
+// Only used to read old packaged strings since args containing it can't be unpackaged correctly
 const DELIMITER = ";"
 
 func CreateGrepQueryFromInput(rawUserInput string) (*GrepQuery, error) {
@@ -28,110 +31,254 @@ func CreateGrepQueryFromInput(rawUserInput string) (*GrepQuery, error) {
 	}
 
 	g.CmdArgs = query
-	g.PackagedString = strings.Join(g.CmdArgs, DELIMITER)
+	g.PackagedString = PackageCmdArgs(g.CmdArgs)
+	g.NormalizedString = PackageCmdArgs(CanonicalizeArgs(g.CmdArgs))
 
 	return g, nil
 }
 
-// Given a packagedString (grep query with cmd args split by "-") it returns a GrepQuery object
+// Creates a GrepQuery from command args already split, ex: received from a client (see internal/rpc).
+// The args are validated like a query typed in
+func CreateGrepQueryFromArgs(cmdArgs []string) (*GrepQuery, error) {
+	if err := validateCmdArgs(cmdArgs); err != nil {
+		return &GrepQuery{}, err
+	}
+	g := &GrepQuery{}
+	g.CmdArgs = append([]string{}, cmdArgs...)
+	g.PackagedString = PackageCmdArgs(g.CmdArgs)
+	g.NormalizedString = PackageCmdArgs(CanonicalizeArgs(g.CmdArgs))
+	return g, nil
+}
+
+// Given a packagedString (see PackageCmdArgs(), or the legacy format) it returns a GrepQuery object.
+// The PackagedString of the returned query is always in the current format
 func CreateGrepQueryFromPackagedString(packagedString string) *GrepQuery {
 	g := &GrepQuery{}
-	g.CmdArgs = strings.Split(packagedString, DELIMITER)
-	g.PackagedString = packagedString
+	g.CmdArgs = UnpackageCmdArgs(packagedString)
+	g.PackagedString = PackageCmdArgs(g.CmdArgs)
+	g.NormalizedString = PackageCmdArgs(CanonicalizeArgs(g.CmdArgs))
 	return g
 }
 
+// Returns the key the output of the query is cached under, so that equivalent queries share a cache entry.
+// Falls back to computing the normalized string for queries that were not created through a constructor
+func (q *GrepQuery) CacheKey() string {
+	if q.NormalizedString != "" {
+		return q.NormalizedString
+	}
+	return PackageCmdArgs(CanonicalizeArgs(q.CmdArgs))
+}
+
+// Packages the command args into one string as a JSON array of strings, ex: ["grep","-c","GET"].
+// Unlike joining the args with a delimiter, this can be unpackaged into the exact same args whatever
+// characters they contain
+func PackageCmdArgs(cmdArgs []string) string {
+	if cmdArgs == nil {
+		cmdArgs = []string{}
+	}
+	buff := new(bytes.Buffer)
+	encoder := json.NewEncoder(buff)
+	encoder.SetEscapeHTML(false) // keep <, > and & readable in JSON test files
+	_ = encoder.Encode(cmdArgs)  // can't fail for a slice of strings
+	return strings.TrimSuffix(buff.String(), "\n")
+}
+
+// Unpackages a packaged string back into the command args. Packaged strings that are not a JSON array
+// are read in the legacy format, i.e. split on DELIMITER
+func UnpackageCmdArgs(packagedString string) []string {
+	if strings.HasPrefix(packagedString, "[") {
+		var cmdArgs []string
+		if err := json.Unmarshal([]byte(packagedString), &cmdArgs); err == nil {
+			return cmdArgs
+		}
+	}
+	return strings.Split(packagedString, DELIMITER)
+}
+
+// Converts a packaged string in the legacy format to the current format.
+// Packaged strings already in the current format are returned as is
+func MigratePackagedString(packagedString string) string {
+	return PackageCmdArgs(UnpackageCmdArgs(packagedString))
+}
+
+// SerializeGrepQuery serializes the query as a JSON object to send over a TCP socket (see docs/protocol.md)
 func SerializeGrepQuery(gquery *GrepQuery) ([]byte, error) {
+	return json.Marshal(gquery)
+}
+
+// SerializeGrepQueryGob serializes the query with gob, the encoding used before JSON, for peers running an older version
+func SerializeGrepQueryGob(gquery *GrepQuery) ([]byte, error) {
 	binary_buff := new(bytes.Buffer)
 
 	encoder := gob.NewEncoder(binary_buff)
 	err := encoder.Encode(gquery)
-	// fmt.Printf("\n--------- SERIALIZE GREP QUERY ---------\nbinary_buff: %v\nbinary_buff.Bytes(): %v\n", binary_buff, binary_buff.Bytes())
 	if err != nil {
 		return nil, err
 	}
 	return binary_buff.Bytes(), nil
 }
 
+// DeserializeGrepQuery deserializes a query serialized as JSON or, for peers running an older version, with gob.
+// Clients written in other languages only have to send CmdArgs, so the packaged and normalized strings are filled in
+// if they are missing. The query isn't validated, see Validate()
 func DeserializeGrepQuery(data []byte) (*GrepQuery, error) {
 	gquery := new(GrepQuery)
-	byteBuffer := bytes.NewBuffer(data)
-	decoder := gob.NewDecoder(byteBuffer)
-
-	err := decoder.Decode(gquery)
-	if err != nil {
+	if IsGobEncoded(data) {
+		decoder := gob.NewDecoder(bytes.NewBuffer(data))
+		if err := decoder.Decode(gquery); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(data, gquery); err != nil {
 		return nil, err
 	}
 
+	if gquery.PackagedString == "" {
+		gquery.PackagedString = PackageCmdArgs(gquery.CmdArgs)
+	}
+	if gquery.NormalizedString == "" {
+		gquery.NormalizedString = PackageCmdArgs(CanonicalizeArgs(gquery.CmdArgs))
+	}
 	return gquery, nil
 }
 
+// Returns true if the query or output was serialized with gob instead of JSON, i.e. doesn't start with a JSON object.
+// gob starts with the length of the type definition of GrepQuery or GrepOutput, which is never '{' (123)
+func IsGobEncoded(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) == 0 || trimmed[0] != '{'
+}
+
+// Validates a query received from another machine or client like a query typed in (see CreateGrepQueryFromInput()),
+// so that only grep is ever run and engine options are well formed
+func (q *GrepQuery) Validate() error {
+	return validateCmdArgs(q.CmdArgs)
+}
+
 // Executes the grep query on the file provided, and returns a GrepOutput object
 func (q *GrepQuery) Execute(filename string) *GrepOutput {
-	// make last arg the file to search -> which will be the log file for machine
-	start := time.Now()
-	cmdLineArgs := append(q.CmdArgs, filename)
-	cmd := exec.Command(cmdLineArgs[0], cmdLineArgs[1:]...) //Define grep command to run, store in cmd
+	return NewExecutor(nil).Execute(q, filename)
+}
 
-	binaryOutput, err := cmd.CombinedOutput() // run command and capture its output
+// Executes the grep query on the byte range [start, end) of the file provided, and returns a GrepOutput object.
+// See Executor.ExecuteRange()
+func (q *GrepQuery) ExecuteRange(filename string, start int64, end int64) *GrepOutput {
+	return NewExecutor(nil).ExecuteRange(q, filename, start, end)
+}
 
-	// make sure there were matches in doing this
-	if err != nil {
-		return &GrepOutput{Output: "", Filename: filepath.Base(filename), NumLines: 0}
+// Returns true if the output of this query over a file can be built by running the query separately
+// over a prefix and the rest of the file and merging the two outputs with MergeIncrementalOutputs().
+// Queries whose output depends on the absolute position in the file (line numbers, byte offsets),
+// on neighbouring lines (context), or on the file as a whole (-m, -l, -L, -q) cannot be merged
+func (q *GrepQuery) SupportsIncrementalMerge() bool {
+	// a time of day bound applies to the date of the last line, which changes as the log grows
+	if tr, ok, err := q.timeRange(); err != nil || (ok && tr.hasTimeOfDayBound()) {
+		return false
+	}
+	return q.supportsSplitting()
+}
+
+// Returns true if the output of this query over a range of a file is the merged outputs of the query over
+// consecutive pieces of the range (see mergeOutputs()), once the range is narrowed down to the time range
+func (q *GrepQuery) supportsSplitting() bool {
+	for _, opt := range q.options() {
+		switch opt.Name {
+		case "-n", "-b", "-m", "-A", "-B", "-C", "-l", "-L", "-q", "-z":
+			return false
+		}
+	}
+	// file patterns depend on the whole file, so can match in the tail and change the lines selected in the prefix
+	if expr, ok, err := q.patternExpr(); err != nil || (ok && expr.HasFilePatterns()) {
+		return false
 	}
+	return true
+}
 
-	outputStr := string(binaryOutput)
-	numLines := strings.Count(outputStr, "\n")
-	end := time.Now()
-	elapsedTime := end.Sub(start)
+// Merges the output of this query over the prefix of a file (cached) with its output over the bytes
+// appended after that prefix (tail). Only valid if SupportsIncrementalMerge() returns true.
+// The execution time of the result is the total time spent grepping the prefix and the tail.
+// Neither output is modified, so cached may be shared with other queries. Neither output may have failed
+func (q *GrepQuery) MergeIncrementalOutputs(cached *GrepOutput, tail *GrepOutput) *GrepOutput {
+	return q.mergeOutputs([]*GrepOutput{cached, tail})
+}
 
-	return &GrepOutput{outputStr, filepath.Base(filename), numLines, elapsedTime}
+// Merges the outputs of this query over consecutive pieces of a file, in file order, into its output over
+// all of them. The execution time of the result is the total time spent grepping the pieces
+func (q *GrepQuery) mergeOutputs(outputs []*GrepOutput) *GrepOutput {
+	merged := &GrepOutput{Filename: outputs[0].Filename}
+	hasAggregates, hasRecords := false, false
+	for _, gOut := range outputs {
+		if gOut.Stderr != "" && !strings.Contains(merged.Stderr, gOut.Stderr) { // same warning for every piece
+			merged.Stderr = strings.TrimPrefix(merged.Stderr+"\n"+gOut.Stderr, "\n")
+		}
+		merged.ExecutionTime += gOut.ExecutionTime
+		merged.ContextLines += gOut.ContextLines
+		merged.NumLines += gOut.NumLines
+		merged.MatchCount += gOut.MatchCount
+		hasAggregates = hasAggregates || gOut.Aggregates != nil
+		hasRecords = hasRecords || gOut.Records != nil
+	}
+
+	if hasAggregates { // add the counts of each group
+		for _, gOut := range outputs {
+			merged.Aggregates = mergeAggregates(merged.Aggregates, gOut.Aggregates)
+		}
+	} else if q.hasOption("-c") { // each output is a single count line (maybe prefixed by the filename), so add the counts
+		count := 0
+		for _, gOut := range outputs {
+			count += parseCount(gOut.Output)
+		}
+		prefix := strings.TrimRight(strings.TrimRight(outputs[0].Output, "\n"), "0123456789")
+		merged.Output = prefix + strconv.Itoa(count) + "\n"
+		merged.NumLines = 1
+		merged.MatchCount = count
+	} else {
+		var output strings.Builder
+		if hasRecords {
+			merged.Records = make([]LogRecord, 0, merged.NumLines)
+		}
+		for _, gOut := range outputs {
+			output.WriteString(gOut.Output)
+			if hasRecords {
+				merged.Records = append(merged.Records, gOut.Records...)
+			}
+		}
+		merged.Output = output.String()
+	}
+
+	merged.ExitStatus = EXIT_NO_MATCH
+	if merged.MatchCount > 0 {
+		merged.ExitStatus = EXIT_MATCH
+	}
+	return merged
 }
 
 // Parses the grep query user entered. Returns a slice containing the individual command arguments
 func parseRawGrepQuery(userInput string) ([]string, error) {
-	// split user input into command and arguments
-	cmdArgs := strings.Fields(userInput) // split based off spaces
-	cmdArgs = handleExtraQuotes(cmdArgs)
+	// split user input into command and arguments, handling quotes and escapes like a shell
+	cmdArgs, err := TokenizeShellInput(userInput)
+	if err != nil {
+		return nil, err
+	}
 
-	// Make sure the user provided atleast two arguments
-	if len(cmdArgs) < 2 {
-		return nil, errors.New("Invalid input! Length of command arguments too small or invalid")
-	} else if cmdArgs[0] != "grep" {
-		return nil, errors.New("Invalid command! Must be a grep command w/o putting the filename")
+	if err = validateCmdArgs(cmdArgs); err != nil {
+		return nil, err
 	}
 
 	return cmdArgs, nil
 }
 
-// Helper function to be used in ParseRawGrepQuery
-// loop through and see if any of the command arguments start with quotations " or ' & handle that
-func handleExtraQuotes(cmdArgs []string) []string {
-	resultGrepQuery := []string{}
-	modifiedCmd := ""
-
-	for _, cmd := range cmdArgs {
-
-		if strings.HasPrefix(cmd, `"`) && strings.HasSuffix(cmd, `"`) {
-
-			cmd = strings.Trim(cmd, `"`)
-			resultGrepQuery = append(resultGrepQuery, cmd)
-		} else {
-
-			if strings.HasPrefix(cmd, `"`) {
-				modifiedCmd = cmd
-			} else if strings.HasSuffix(cmd, `"`) {
-				modifiedCmd = modifiedCmd + " " + cmd
-				trimmedCmd := strings.Trim(modifiedCmd, `"`)
-				resultGrepQuery = append(resultGrepQuery, trimmedCmd)
-				modifiedCmd = ""
-			} else if modifiedCmd != "" {
-				modifiedCmd = modifiedCmd + " " + cmd
-			} else {
-				resultGrepQuery = append(resultGrepQuery, cmd)
-			}
-		}
+// Makes sure the command args are a grep command with at least one argument that only searches the log file,
+// and has valid engine options
+func validateCmdArgs(cmdArgs []string) error {
+	// Make sure the user provided atleast two arguments
+	if len(cmdArgs) < 2 {
+		return errors.New("Invalid input! Length of command arguments too small or invalid")
+	} else if cmdArgs[0] != "grep" {
+		return errors.New("Invalid command! Must be a grep command w/o putting the filename")
 	}
 
-	return resultGrepQuery
+	if err := validateFileAccess(cmdArgs); err != nil {
+		return err
+	}
+	return validateEngineOptions(cmdArgs)
 }
@@ -0,0 +1,56 @@
+package grep
+
+import (
+	"strings"
+)
+
+// Characters that make a pattern more than a literal string in basic regular expressions (the default)
+const BRE_META_CHARS = `\.[]*^$`
+
+// Characters that make a pattern more than a literal string in extended (-E) and Perl (-P) regular expressions
+const ERE_META_CHARS = `\.[]*^$+?(){}|`
+
+// Returns the literal strings one of which every line the query matches must contain, so that an index of the
+// log file can rule out the parts of the file that contain none of them (see index.LogIndex). Returns false if
+// the query's patterns aren't all literal strings, or the query's output depends on lines that don't match
+// (ex: -v, context lines) or on the position of the lines in the file (-n, -b)
+func (q *GrepQuery) indexLiterals() ([]string, bool) {
+	if _, hasExpr := q.EngineOption("expr"); hasExpr {
+		return nil, false
+	}
+	metaChars := BRE_META_CHARS
+	patterns := make([]string, 0)
+	opts, operands := parseGrepArgs(q.grepCmdArgs()[1:])
+	for _, opt := range opts {
+		switch opt.Name {
+		case "-v", "-f", "-n", "-b", "-A", "-B", "-C", "-z":
+			return nil, false
+		case "-E", "-P":
+			metaChars = ERE_META_CHARS
+		case "-F":
+			metaChars = ""
+		case "-e":
+			patterns = append(patterns, opt.Value)
+		}
+	}
+	if len(patterns) == 0 {
+		if len(operands) == 0 { // ex: --where w/o a pattern, which searches every line
+			return nil, false
+		}
+		patterns = append(patterns, operands[0])
+	}
+
+	literals := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		for _, literal := range strings.Split(pattern, "\n") { // each line of a pattern is a pattern
+			if metaChars != "" { // anchors don't change what the line must contain
+				literal = strings.TrimSuffix(strings.TrimPrefix(literal, "^"), "$")
+			}
+			if strings.ContainsAny(literal, metaChars) {
+				return nil, false
+			}
+			literals = append(literals, literal)
+		}
+	}
+	return literals, true
+}
@@ -0,0 +1,56 @@
+package grep
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns true if the query only reports whether the file matches (-l, -L or -q) instead of the matching lines
+func (q *GrepQuery) isListMode() bool {
+	return q.hasOption("-l") || q.hasOption("-L") || q.hasOption("-q")
+}
+
+// Returns the grep args to count the matching lines of a query with -l, -L or -q, which would stop at the first
+// match: the same args with -c instead. The output of the query is then built from the count (see listModeOutput())
+func prepareArgsForListMode(grepArgs []string) []string {
+	args := withoutFlags(grepArgs, "lLq")
+	return append([]string{args[0], "-c"}, args[1:]...)
+}
+
+// Returns the output grep would have printed for a query with -l, -L or -q given the number of matching lines
+// and the filename grep prints
+func (q *GrepQuery) listModeOutput(count int, displayName string) string {
+	terminator := "\n"
+	if q.hasOption("-Z") { // filenames end with a null byte instead of a new line
+		terminator = "\x00"
+	}
+	switch {
+	case q.hasOption("-q"):
+		return ""
+	case q.hasOption("-l") && count > 0:
+		return displayName + terminator
+	case q.hasOption("-L") && count == 0:
+		return displayName + terminator
+	}
+	return ""
+}
+
+// Parses the count printed by grep -c, which may come after the filename (-H), ex: "vm1.log:12"
+func parseCount(output string) int {
+	output = strings.TrimRight(output, "\n")
+	start := len(output)
+	for start > 0 && output[start-1] >= '0' && output[start-1] <= '9' {
+		start--
+	}
+	count, _ := strconv.Atoi(output[start:])
+	return count
+}
+
+// Returns the filename grep prints for the input: the label of the range fed through stdin, or the file as given
+func grepDisplayName(filename string, fromStdin bool) string {
+	if fromStdin {
+		return filepath.Base(filename)
+	}
+	return filename
+}
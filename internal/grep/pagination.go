@@ -0,0 +1,166 @@
+package grep
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Engine options that select a page of the output lines of a query, w/o changing the output the page is taken from
+var paginationOptions = map[string]bool{"max-results": true, "max-results-per-machine": true, "cursor": true}
+
+// Page of the output lines of a query given with --max-results N (and --cursor for the pages after the first).
+// Each machine only sends back N of its output lines, starting after the ones returned in the previous pages,
+// and the querying machine keeps N of them in total (see LimitResults()). Every page is taken from the output
+// of the query w/o the pagination options, so the later pages are served from the cache instead of grepping again.
+// Since the lines are kept in machine order, the first machine with N matching lines fills the whole page. With
+// --max-results-per-machine M (less than N), each machine only sends back M lines, so the page has lines of more of them
+type Page struct {
+	MaxResults           int
+	MaxResultsPerMachine int            // equal to MaxResults w/o --max-results-per-machine
+	offsets              map[string]int // machine -> number of its output lines returned in the previous pages. nil on the first page
+}
+
+// Returns the page of the query's output, and false if the query doesn't have --max-results
+func (q *GrepQuery) Page() (*Page, bool, error) {
+	maxResults, hasMaxResults := q.EngineOption("max-results")
+	perMachine, hasPerMachine := q.EngineOption("max-results-per-machine")
+	cursor, hasCursor := q.EngineOption("cursor")
+	if !hasMaxResults {
+		if hasCursor {
+			return nil, false, fmt.Errorf("Invalid input! --cursor must be combined with --max-results")
+		}
+		if hasPerMachine {
+			return nil, false, fmt.Errorf("Invalid input! --max-results-per-machine must be combined with --max-results")
+		}
+		return nil, false, nil
+	}
+
+	n, err := strconv.Atoi(maxResults)
+	if err != nil || n <= 0 {
+		return nil, false, fmt.Errorf("Invalid input! --max-results must be a positive number, but got %q", maxResults)
+	}
+	perMachineN := n
+	if hasPerMachine {
+		perMachineN, err = strconv.Atoi(perMachine)
+		if err != nil || perMachineN <= 0 || perMachineN > n {
+			return nil, false, fmt.Errorf("Invalid input! --max-results-per-machine must be a positive number up to --max-results, but got %q", perMachine)
+		}
+	}
+	for _, opt := range q.options() { // these output counts or file names instead of lines
+		if opt.Name == "-c" || opt.Name == "-l" || opt.Name == "-L" || opt.Name == "-q" || opt.Name == "--count-by" {
+			return nil, false, fmt.Errorf("Invalid input! %s can't be combined with --max-results", opt.Name)
+		}
+	}
+
+	page := &Page{MaxResults: n, MaxResultsPerMachine: perMachineN}
+	if hasCursor {
+		if page.offsets, err = parseCursor(cursor); err != nil {
+			return nil, false, err
+		}
+	}
+	return page, true, nil
+}
+
+// Parses a cursor like "fa23-cs425-1901.cs.illinois.edu:100,fa23-cs425-1902.cs.illinois.edu:40"
+func parseCursor(cursor string) (map[string]int, error) {
+	offsets := make(map[string]int)
+	if cursor == "" {
+		return offsets, nil
+	}
+	for _, entry := range strings.Split(cursor, ",") {
+		sep := strings.LastIndexByte(entry, ':')
+		offset, err := strconv.Atoi(entry[sep+1:])
+		if sep <= 0 || err != nil || offset < 0 {
+			return nil, fmt.Errorf("Invalid input! --cursor must be the cursor printed with the previous page, but got %q", cursor)
+		}
+		offsets[entry[:sep]] = offset
+	}
+	return offsets, nil
+}
+
+// Returns the query w/o --max-results and --cursor, i.e. the query whose output every page is taken from
+func (q *GrepQuery) WithoutPagination() *GrepQuery {
+	return CreateGrepQueryFromPackagedString(PackageCmdArgs(q.argsWithout(paginationOptions)))
+}
+
+// Returns the query of the page starting at the cursor
+func (q *GrepQuery) WithCursor(cursor string) *GrepQuery {
+	args := q.argsWithout(map[string]bool{"cursor": true})
+	// right after "grep", so the cursor is still an option if the query uses "--"
+	args = append([]string{args[0], "--cursor=" + cursor}, args[1:]...)
+	return CreateGrepQueryFromPackagedString(PackageCmdArgs(args))
+}
+
+// Returns the page of the machine's output: at most MaxResultsPerMachine of its output lines, after the ones returned in the
+// previous pages. The returned copy has the number of lines skipped in Offset, and Truncated set if there are more
+// lines after the page. Its counts are still those of the whole output. gOut is not modified
+func (p *Page) Apply(gOut *GrepOutput, machine string) *GrepOutput {
+	if gOut.Failed() {
+		return gOut
+	}
+	offset := gOut.NumLines
+	if p.offsets == nil {
+		offset = 0
+	} else if machineOffset, ok := p.offsets[machine]; ok && machineOffset < gOut.NumLines {
+		offset = machineOffset
+	} // else all of the machine's lines were returned in the previous pages
+
+	paged := *gOut
+	paged.Offset = offset
+	paged.cutLines(offset, offset+p.MaxResultsPerMachine)
+	return &paged
+}
+
+// LimitResults cuts the pages of every machine's output down to MaxResults lines in total, keeping the lines of the
+// machines in order, and returns the cursor of the next page, or "" if every line of every machine was returned.
+// Machines that failed are asked for the same page again
+func (p *Page) LimitResults(outputs []GrepOutput) string {
+	remaining := p.MaxResults
+	next := make([]string, 0)
+	for i := range outputs {
+		gOut := &outputs[i]
+		if gOut.Failed() {
+			if offset, ok := p.offsets[gOut.Machine]; ok || p.offsets == nil {
+				next = append(next, fmt.Sprintf("%s:%d", gOut.Machine, offset))
+			}
+			continue
+		}
+		if gOut.NumLines > remaining {
+			gOut.cutLines(0, remaining)
+		}
+		remaining -= gOut.NumLines
+		if gOut.Truncated {
+			next = append(next, fmt.Sprintf("%s:%d", gOut.Machine, gOut.Offset+gOut.NumLines))
+		}
+	}
+	return strings.Join(next, ",")
+}
+
+// Only keeps the lines [start, end) of the output (and their records), setting Truncated if lines after end are cut
+func (g *GrepOutput) cutLines(start int, end int) {
+	if end > g.NumLines {
+		end = g.NumLines
+	}
+	if len(g.Records) == g.NumLines { // every line has a record, with --where
+		g.Records = g.Records[start:end]
+	}
+	startByte := lineStart(g.Output, start)
+	endByte := startByte + lineStart(g.Output[startByte:], end-start)
+	g.Truncated = g.Truncated || end < g.NumLines
+	g.Output = g.Output[startByte:endByte]
+	g.NumLines = end - start
+}
+
+// Returns the index of the start of the nth line of the string (its length if it has n lines or less)
+func lineStart(s string, n int) int {
+	pos := 0
+	for i := 0; i < n; i++ {
+		next := strings.IndexByte(s[pos:], '\n')
+		if next == -1 {
+			return len(s)
+		}
+		pos += next + 1
+	}
+	return pos
+}
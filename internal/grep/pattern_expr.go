@@ -0,0 +1,341 @@
+package grep
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Grep options that can be combined with --expr, since the expression is evaluated by the engine instead of grep
+const EXPR_OPTIONS = "iFwc"
+
+// Prefix of the patterns in a pattern expression that must match somewhere in the file instead of on the line
+const FILE_PATTERN_PREFIX = "file:"
+
+// Result of evaluating a pattern expression before the whole file was read. A file pattern that hasn't matched
+// yet is unknown, since it may still match a later line
+type tristate int
+
+const (
+	triFalse tristate = iota
+	triTrue
+	triUnknown
+)
+
+func (t tristate) and(other tristate) tristate {
+	if t == triFalse || other == triFalse {
+		return triFalse
+	}
+	if t == triTrue && other == triTrue {
+		return triTrue
+	}
+	return triUnknown
+}
+
+func (t tristate) or(other tristate) tristate {
+	if t == triTrue || other == triTrue {
+		return triTrue
+	}
+	if t == triFalse && other == triFalse {
+		return triFalse
+	}
+	return triUnknown
+}
+
+func (t tristate) not() tristate {
+	switch t {
+	case triTrue:
+		return triFalse
+	case triFalse:
+		return triTrue
+	}
+	return triUnknown
+}
+
+// PatternExpr is a boolean expression over patterns, ex: `ERROR AND NOT timeout AND file:"Cache cleared"`,
+// which selects the lines it is true for
+type PatternExpr struct {
+	root     patternNode
+	patterns []*exprPattern
+}
+
+// A pattern of a pattern expression. Line patterns are true if they match the line, and file patterns are
+// true if they match any line of the file
+type exprPattern struct {
+	source string // as written in the expression, w/o the file: prefix
+	isFile bool
+	regex  *regexp.Regexp
+}
+
+type patternNode interface {
+	// evaluates the node given the matches of the line patterns on the line and the file patterns seen so far
+	eval(lineMatches []bool, fileMatches []tristate) tristate
+}
+
+type patternAndNode struct{ left, right patternNode }
+type patternOrNode struct{ left, right patternNode }
+type patternNotNode struct{ inner patternNode }
+type patternAtomNode struct {
+	index  int // index of the pattern in PatternExpr.patterns
+	isFile bool
+}
+
+func (n *patternAndNode) eval(lineMatches []bool, fileMatches []tristate) tristate {
+	return n.left.eval(lineMatches, fileMatches).and(n.right.eval(lineMatches, fileMatches))
+}
+
+func (n *patternOrNode) eval(lineMatches []bool, fileMatches []tristate) tristate {
+	return n.left.eval(lineMatches, fileMatches).or(n.right.eval(lineMatches, fileMatches))
+}
+
+func (n *patternNotNode) eval(lineMatches []bool, fileMatches []tristate) tristate {
+	return n.inner.eval(lineMatches, fileMatches).not()
+}
+
+func (n *patternAtomNode) eval(lineMatches []bool, fileMatches []tristate) tristate {
+	if n.isFile {
+		return fileMatches[n.index]
+	}
+	if lineMatches[n.index] {
+		return triTrue
+	}
+	return triFalse
+}
+
+// ParsePatternExpr parses a pattern expression with the same syntax as filters (see ParseFilter()), except that
+// the comparisons are replaced by patterns: a word or a double quoted string, which is a regular expression
+// (RE2 syntax, like grep -E). A pattern prefixed with "file:" must match some line of the file instead of the line
+// itself, ex: `ERROR AND NOT timeout AND file:"Cache cleared"`. ignoreCase, fixedStrings and wholeWords apply the
+// grep options -i, -F and -w to every pattern
+func ParsePatternExpr(expr string, ignoreCase bool, fixedStrings bool, wholeWords bool) (*PatternExpr, error) {
+	p := &patternParser{filterParser: filterParser{input: expr, kind: "pattern expression"}, ignoreCase: ignoreCase,
+		fixedStrings: fixedStrings, wholeWords: wholeWords}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return &PatternExpr{root: root, patterns: p.patterns}, nil
+}
+
+// Returns true if the expression has file patterns, whose result depends on the whole file
+func (e *PatternExpr) HasFilePatterns() bool {
+	for _, pattern := range e.patterns {
+		if pattern.isFile {
+			return true
+		}
+	}
+	return false
+}
+
+// A line that may be part of the output of a pattern expression, kept until the file patterns are known
+type exprLine struct {
+	text        string
+	lineMatches []bool // nil if the line is known to be in the output
+}
+
+// Evaluates the expression on every line of the input in a single pass, and returns the lines it is true for.
+// Lines whose result depends on file patterns that haven't matched yet are kept until the end of the input,
+// when every file pattern that hasn't matched is known to be false. If countOnly is set, returns the number
+// of lines like "grep -c" would. Returns false if no lines matched, and an error if the input couldn't be read
+func (e *PatternExpr) Run(input io.Reader, countOnly bool) (string, bool, error) {
+	fileMatches := make([]tristate, len(e.patterns))
+	for i := range fileMatches {
+		fileMatches[i] = triUnknown
+	}
+
+	lines := make([]exprLine, 0)
+	reader := bufio.NewReader(input)
+	for {
+		text, err := reader.ReadString('\n')
+		if len(text) > 0 {
+			text = strings.TrimSuffix(text, "\n")
+			lineMatches := make([]bool, len(e.patterns))
+			for i, pattern := range e.patterns {
+				lineMatches[i] = pattern.regex.MatchString(text)
+				if pattern.isFile && lineMatches[i] {
+					fileMatches[i] = triTrue
+				}
+			}
+
+			switch e.root.eval(lineMatches, fileMatches) {
+			case triTrue:
+				lines = append(lines, exprLine{text: text})
+			case triUnknown:
+				lines = append(lines, exprLine{text: text, lineMatches: lineMatches})
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", false, err
+		}
+	}
+
+	for i := range fileMatches { // whole input was read, so file patterns that haven't matched never will
+		if fileMatches[i] == triUnknown {
+			fileMatches[i] = triFalse
+		}
+	}
+
+	var output strings.Builder
+	count := 0
+	for _, line := range lines {
+		if line.lineMatches != nil && e.root.eval(line.lineMatches, fileMatches) != triTrue {
+			continue
+		}
+		count++
+		if !countOnly {
+			output.WriteString(line.text)
+			output.WriteByte('\n')
+		}
+	}
+
+	if countOnly {
+		return strconv.Itoa(count) + "\n", count > 0, nil
+	}
+	return output.String(), count > 0, nil
+}
+
+// Returns the pattern expression of the query, and false if it doesn't have --expr
+func (q *GrepQuery) patternExpr() (*PatternExpr, bool, error) {
+	expr, ok := q.EngineOption("expr")
+	if !ok {
+		return nil, false, nil
+	}
+	patternExpr, err := ParsePatternExpr(expr, q.hasOption("-i"), q.hasOption("-F"), q.hasOption("-w"))
+	if err != nil {
+		return nil, false, err
+	}
+	return patternExpr, true, nil
+}
+
+// Runs the pattern expression on the input, or on the file if input is nil.
+// Returns the output, the exit status grep would have, and the error message if it failed
+func runPatternExpr(expr *PatternExpr, filename string, input io.Reader, countOnly bool) (string, int, string) {
+	if input == nil {
+		file, err := os.Open(filename)
+		if err != nil {
+			return "", EXIT_ERROR, err.Error()
+		}
+		defer func(file *os.File) {
+			_ = file.Close()
+		}(file)
+		input = file
+	}
+
+	output, matched, err := expr.Run(input, countOnly)
+	if err != nil {
+		return "", EXIT_ERROR, err.Error()
+	}
+	if !matched {
+		return output, EXIT_NO_MATCH, ""
+	}
+	return output, EXIT_MATCH, ""
+}
+
+// Parser for pattern expressions, which reuses the filter parser for everything but the patterns
+type patternParser struct {
+	filterParser
+	patterns                             []*exprPattern
+	ignoreCase, fixedStrings, wholeWords bool
+}
+
+func (p *patternParser) parseOr() (patternNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &patternOrNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *patternParser) parseAnd() (patternNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &patternAndNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *patternParser) parseNot() (patternNode, error) {
+	if p.acceptKeyword("NOT") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &patternNotNode{inner}, nil
+	}
+
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, p.errorf("expected \")\"")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return p.parsePattern()
+}
+
+func (p *patternParser) parsePattern() (patternNode, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("expected a pattern but the expression ended")
+	}
+	isFile := strings.HasPrefix(p.input[p.pos:], FILE_PATTERN_PREFIX)
+	if isFile {
+		p.pos += len(FILE_PATTERN_PREFIX)
+	}
+
+	start := p.pos
+	source, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	regexSource := source
+	if p.fixedStrings {
+		regexSource = regexp.QuoteMeta(regexSource)
+	}
+	if p.wholeWords {
+		regexSource = `\b(?:` + regexSource + `)\b`
+	}
+	if p.ignoreCase {
+		regexSource = "(?i)" + regexSource
+	}
+	regex, err := regexp.Compile(regexSource)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid regex %q: %v", source, err)
+	}
+
+	p.patterns = append(p.patterns, &exprPattern{source: source, isFile: isFile, regex: regex})
+	return &patternAtomNode{index: len(p.patterns) - 1, isFile: isFile}, nil
+}
@@ -0,0 +1,206 @@
+package grep
+
+import (
+	"bufio"
+	"bytes"
+	"cs425_mp1/internal/logformat"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Number of bytes read backwards from the end of a range when looking for its last timestamp
+const LAST_TIMESTAMP_CHUNK_SIZE = 64 * 1024
+
+// Time bounds of a query given with --since and --until. Both bounds are inclusive, and --until includes all of
+// the time it names, ex: "--until 2023-09-06" includes every line of that day
+type timeRange struct {
+	since, until                   time.Time
+	untilPrecision                 time.Duration // how long the until bound lasts, ex: a minute for "23:00"
+	hasSince, hasUntil             bool
+	sinceTimeOfDay, untilTimeOfDay bool // true if the bound is only a time of day, ex: "22:50"
+}
+
+// Returns the time bounds of the query, and false if it has neither --since nor --until
+func (q *GrepQuery) timeRange() (*timeRange, bool, error) {
+	tr := &timeRange{}
+	var err error
+	if value, ok := q.EngineOption("since"); ok {
+		if tr.since, _, tr.sinceTimeOfDay, err = logformat.ParseTimeBound(value); err != nil {
+			return nil, false, err
+		}
+		tr.hasSince = true
+	}
+	if value, ok := q.EngineOption("until"); ok {
+		if tr.until, tr.untilPrecision, tr.untilTimeOfDay, err = logformat.ParseTimeBound(value); err != nil {
+			return nil, false, err
+		}
+		tr.hasUntil = true
+	}
+	// two times of day are never out of order, ex: "--since 23:50 --until 00:10" is the 20 minutes around midnight
+	if tr.hasSince && tr.hasUntil && !tr.sinceTimeOfDay && !tr.untilTimeOfDay && !tr.since.Before(tr.untilEnd(tr.until)) {
+		return nil, false, fmt.Errorf("Invalid input! --since %s is after --until %s", tr.since.Format("2006-01-02 15:04:05"), tr.until.Format("2006-01-02 15:04:05"))
+	}
+	return tr, tr.hasSince || tr.hasUntil, nil
+}
+
+// Returns the first instant after the until bound, given the until bound on the date it applies to
+func (tr *timeRange) untilEnd(until time.Time) time.Time {
+	return until.Add(tr.untilPrecision)
+}
+
+// Returns true if either bound is only a time of day, which is resolved against the timestamp of the last line
+// in the range being searched (see resolveTimesOfDay())
+func (tr *timeRange) hasTimeOfDayBound() bool {
+	return (tr.hasSince && tr.sinceTimeOfDay) || (tr.hasUntil && tr.untilTimeOfDay)
+}
+
+// Moves the bounds that are only a time of day to the dates they apply to, given the timestamp of the last line.
+// --since is the last time that time of day came before the last line, so "--since 22:50" still finds yesterday's
+// lines once the log is past midnight. --until is the first time that time of day comes after --since, so the range
+// can span midnight, or is on the date of the last line if there is no --since
+func (tr *timeRange) resolveTimesOfDay(lastTs time.Time) (time.Time, time.Time) {
+	since, until := tr.since, tr.until
+	if tr.hasSince && tr.sinceTimeOfDay {
+		since = logformat.OnDate(since, lastTs)
+		if since.After(lastTs) {
+			since = since.AddDate(0, 0, -1)
+		}
+	}
+	if tr.hasUntil && tr.untilTimeOfDay {
+		if tr.hasSince {
+			until = logformat.OnDate(until, since)
+			if !tr.untilEnd(until).After(since) {
+				until = until.AddDate(0, 0, 1)
+			}
+		} else {
+			until = logformat.OnDate(until, lastTs)
+		}
+	}
+	return since, until
+}
+
+// Narrows the byte range [start, end) of the log file (which must start on a line boundary) down to the lines
+// with timestamps in the time range, by binary searching the offsets of the range. Assumes the log file is
+// sorted by time, which is the case for logs that are only appended to. Lines w/o a timestamp (ex: the rest of
+// a multi-line message) are treated as part of the closest line above them that has one
+func (e *Executor) narrowToTimeRange(file *os.File, start int64, end int64, tr *timeRange) (int64, int64) {
+	since, until := tr.since, tr.until
+	if tr.hasTimeOfDayBound() {
+		lastTs, ok := e.lastTimestamp(file, start, end)
+		if !ok { // no line has a timestamp, so no line is in the time range
+			return start, start
+		}
+		since, until = tr.resolveTimesOfDay(lastTs)
+	}
+
+	lo, hi := start, end
+	if tr.hasSince { // first line at or after since
+		lo = e.searchOffset(file, start, end, func(ts time.Time) bool { return !ts.Before(since) })
+	}
+	if tr.hasUntil { // first line after all of until
+		untilEnd := tr.untilEnd(until)
+		hi = e.searchOffset(file, lo, end, func(ts time.Time) bool { return !ts.Before(untilEnd) })
+	}
+	return lo, hi
+}
+
+// Returns the start of the first line in [start, end) whose timestamp satisfies pred, or end if there is none.
+// pred must be false for all lines before some line and true for all lines after it
+func (e *Executor) searchOffset(file *os.File, start int64, end int64, pred func(time.Time) bool) int64 {
+	// smallest offset x in [start, end] such that the first timestamped line starting at or after x satisfies pred
+	lo, hi := start, end
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if _, ts, found := e.nextTimestampedLine(file, start, mid, end); !found || pred(ts) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	if lo == start {
+		return start
+	}
+	lineStart, _, found := e.nextTimestampedLine(file, start, lo, end)
+	if !found {
+		return end
+	}
+	return lineStart
+}
+
+// Returns the start offset and timestamp of the first line with a timestamp that starts at or after offset
+// and before end. rangeStart is the start of the range being searched, which is always a line start
+func (e *Executor) nextTimestampedLine(file *os.File, rangeStart int64, offset int64, end int64) (int64, time.Time, bool) {
+	reader := bufio.NewReader(io.NewSectionReader(file, offset, end-offset))
+	pos := offset
+
+	if offset > rangeStart { // offset may be in the middle of a line, so skip to the start of the next one
+		prev := make([]byte, 1)
+		if _, err := file.ReadAt(prev, offset-1); err != nil {
+			return 0, time.Time{}, false
+		}
+		if prev[0] != '\n' {
+			skipped, err := reader.ReadSlice('\n')
+			for err == bufio.ErrBufferFull { // line longer than the buffer
+				pos += int64(len(skipped))
+				skipped, err = reader.ReadSlice('\n')
+			}
+			if err != nil {
+				return 0, time.Time{}, false
+			}
+			pos += int64(len(skipped))
+		}
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) == 0 {
+			return 0, time.Time{}, false
+		}
+		if ts, ok := e.LineTimestamp(line); ok {
+			return pos, ts, true
+		}
+		pos += int64(len(line))
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+	}
+}
+
+// Returns the timestamp of the last line in [start, end) that has one
+func (e *Executor) lastTimestamp(file *os.File, start int64, end int64) (time.Time, bool) {
+	chunkStart := end - LAST_TIMESTAMP_CHUNK_SIZE
+	if chunkStart < start {
+		chunkStart = start
+	}
+	chunk := make([]byte, end-chunkStart)
+	if _, err := file.ReadAt(chunk, chunkStart); err != nil && err != io.EOF {
+		return time.Time{}, false
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(chunk, []byte("\n")), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		if i == 0 && chunkStart > start { // first line of the chunk may be cut off
+			break
+		}
+		if ts, ok := e.LineTimestamp(string(lines[i])); ok {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// LineTimestamp parses the timestamp of a log line from the "time" field the log parser splits it into.
+// Returns false if the line has no timestamp
+func (e *Executor) LineTimestamp(line string) (time.Time, bool) {
+	fields, ok := e.logParser.Parse(string(bytes.TrimRight([]byte(line), "\r\n")))
+	if !ok {
+		return time.Time{}, false
+	}
+	value, ok := fields["time"]
+	if !ok {
+		return time.Time{}, false
+	}
+	return logformat.ParseTimestamp(value)
+}
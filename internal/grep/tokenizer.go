@@ -0,0 +1,102 @@
+package grep
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Splits the user's input into words the way a POSIX shell would, so that patterns can be typed
+// in as they would be on the command line:
+//   - words are separated by any amount of unquoted spaces, tabs or new lines
+//   - single quotes preserve everything up to the next single quote literally
+//   - double quotes preserve everything up to the next unescaped double quote, except that a backslash
+//     escapes a following double quote, backslash, dollar sign or backtick (any other backslash is kept as is)
+//   - an unquoted backslash escapes the following character (ex: `\ ` is a space inside a word)
+//   - quoted and unquoted parts next to each other form a single word (ex: `'a b'"c"d` -> `a bcd`),
+//     and empty quotes form an empty word
+//
+// Expansions, pipes and redirections are not supported - `$`, `|`, `>` etc. are ordinary characters.
+// Returns an error for an unterminated quote or a trailing backslash
+func TokenizeShellInput(input string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false // true if a word was started, even if it is empty so far (ex: '')
+
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			if i+1 >= len(input) {
+				return nil, fmt.Errorf("Invalid input! Trailing backslash at position %d has nothing to escape", i+1)
+			}
+			i++
+			word.WriteByte(input[i])
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(input[i+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("Invalid input! Unterminated single quote opened at position %d", i+1)
+			}
+			word.WriteString(input[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			end, err := readDoubleQuoted(input, i, &word)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// Helper function for TokenizeShellInput - writes the contents of the double quoted string starting at
+// input[start] into word, and returns the index of the closing double quote
+func readDoubleQuoted(input string, start int, word *strings.Builder) (int, error) {
+	for i := start + 1; i < len(input); i++ {
+		c := input[i]
+		if c == '"' {
+			return i, nil
+		}
+		if c == '\\' && i+1 < len(input) && strings.IndexByte("\"\\$`", input[i+1]) != -1 {
+			i++
+			c = input[i]
+		}
+		word.WriteByte(c)
+	}
+	return 0, fmt.Errorf("Invalid input! Unterminated double quote opened at position %d", start+1)
+}
+
+// QuoteShellArgs joins the args into a single string that TokenizeShellInput splits back into the same args.
+// Args that contain no special characters are left as is, and all others are single quoted
+func QuoteShellArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteShellArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Helper function for QuoteShellArgs
+func quoteShellArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\r\\'\"") {
+		return arg
+	}
+	// a single quote can't appear inside single quotes, so close the quotes, escape it, and reopen them
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
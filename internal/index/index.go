@@ -0,0 +1,264 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// Default number of bytes of the log file in each block of the index. Smaller blocks narrow queries down
+// further but take up more memory, since every trigram keeps a bit per block
+const DEFAULT_BLOCK_SIZE = 64 * 1024
+
+// Number of bytes before the indexed end of the file compared to detect a truncated and rewritten file
+const BOUNDARY_CHECK_SIZE = 64
+
+// Number of bytes in a trigram
+const TRIGRAM_SIZE = 3
+
+// Range of bytes [Start, End) of the log file
+type Range struct {
+	Start, End int64
+}
+
+// Stats of the index, ex: to print how much of the log file it covers
+type Stats struct {
+	IndexedBytes int64 // number of bytes of the log file covered by the index
+	Blocks       int
+	Trigrams     int // number of distinct trigrams in the log file
+}
+
+// LogIndex is a trigram index of a log file, which splits the file into blocks of whole lines and records
+// which blocks contain each trigram (3 consecutive bytes, lower cased). A line containing a string contains
+// all of its trigrams, so only the blocks containing all of them can have lines matching it.
+// The index is built incrementally: every call to Update() indexes the lines appended since the last call,
+// and rebuilds the index from scratch if the file was truncated or rotated. Safe for concurrent use
+type LogIndex struct {
+	mutex     sync.RWMutex
+	filename  string
+	blockSize int64
+
+	blocks     []Range             // blocks of the file, in order. Each one ends on a line boundary
+	trigrams   map[uint32][]uint64 // trigram -> bitset of the blocks containing it
+	indexedEnd int64               // end of the last block
+	fileInfo   os.FileInfo         // info of the file when it was last indexed, used to detect rotation
+	boundary   []byte              // last bytes before indexedEnd, used to detect truncation
+}
+
+// New creates an empty index for the log file with blocks of about blockSize bytes (DEFAULT_BLOCK_SIZE if <= 0).
+// Call Update() to index the file
+func New(filename string, blockSize int) *LogIndex {
+	if blockSize <= 0 {
+		blockSize = DEFAULT_BLOCK_SIZE
+	}
+	idx := &LogIndex{filename: filename, blockSize: int64(blockSize)}
+	idx.reset(nil)
+	return idx
+}
+
+// Returns the log file this index is for
+func (idx *LogIndex) Filename() string {
+	return idx.filename
+}
+
+// Update indexes the complete lines appended to the log file since the last update. If the file was rotated
+// or truncated, the whole file is indexed again
+func (idx *LogIndex) Update() error {
+	file, err := os.Open(idx.filename)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	if !idx.isPrefixOf(file, info) {
+		idx.reset(info)
+	}
+	if info.Size() == idx.indexedEnd {
+		return nil
+	}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, idx.indexedEnd, info.Size()-idx.indexedEnd), int(idx.blockSize))
+	blockStart := idx.indexedEnd
+	blockTrigrams := make(map[uint32]struct{})
+	pos := blockStart
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull { // line longer than the buffer, so put its pieces together
+			longLine := append([]byte{}, line...)
+			for err == bufio.ErrBufferFull {
+				line, err = reader.ReadSlice('\n')
+				longLine = append(longLine, line...)
+			}
+			line = longLine
+		}
+		if err != nil { // partial line at the end of the file isn't indexed until it is complete
+			break
+		}
+		addTrigrams(blockTrigrams, line)
+		pos += int64(len(line))
+		if pos-blockStart >= idx.blockSize {
+			idx.addBlock(Range{blockStart, pos}, blockTrigrams)
+			blockStart = pos
+			blockTrigrams = make(map[uint32]struct{})
+		}
+	}
+	if pos > blockStart {
+		idx.addBlock(Range{blockStart, pos}, blockTrigrams)
+	}
+
+	idx.fileInfo = info
+	idx.boundary = readBoundary(file, idx.indexedEnd)
+	return nil
+}
+
+// CandidateRanges returns the ranges of [start, end) that may contain lines matching the query, given the literal
+// strings a matching line must contain at least one of (ex: the patterns of "grep -e ERROR -e CRITICAL").
+// Ranges after the end of the index are always candidates. If there are no literals or any literal is shorter
+// than a trigram, nothing can be ruled out and [start, end) is returned as is. start and end must be on line boundaries
+func (idx *LogIndex) CandidateRanges(literals []string, start int64, end int64) []Range {
+	if len(literals) == 0 {
+		return []Range{{start, end}}
+	}
+	for _, literal := range literals {
+		if len(literal) < TRIGRAM_SIZE {
+			return []Range{{start, end}}
+		}
+	}
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	candidates := make([]uint64, (len(idx.blocks)+63)/64)
+	for _, literal := range literals { // a block is a candidate if it contains all trigrams of some literal
+		blocks := idx.blocksWithAll(literal)
+		for i := range candidates {
+			candidates[i] |= blocks[i]
+		}
+	}
+
+	ranges := make([]Range, 0)
+	for i, block := range idx.blocks {
+		if candidates[i/64]&(1<<(i%64)) != 0 {
+			ranges = appendClipped(ranges, block, start, end)
+		}
+	}
+	if idx.indexedEnd < end {
+		ranges = appendClipped(ranges, Range{idx.indexedEnd, end}, start, end)
+	}
+	return ranges
+}
+
+// Returns the stats of the index
+func (idx *LogIndex) Stats() Stats {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+	return Stats{IndexedBytes: idx.indexedEnd, Blocks: len(idx.blocks), Trigrams: len(idx.trigrams)}
+}
+
+// Returns a bitset of the blocks that contain every trigram of the literal
+func (idx *LogIndex) blocksWithAll(literal string) []uint64 {
+	blocks := make([]uint64, (len(idx.blocks)+63)/64)
+	for i := range blocks {
+		blocks[i] = ^uint64(0)
+	}
+	lower := bytes.ToLower([]byte(literal))
+	for i := 0; i+TRIGRAM_SIZE <= len(lower); i++ {
+		bitset := idx.trigrams[trigramAt(lower, i)]
+		for j := range blocks {
+			if j < len(bitset) {
+				blocks[j] &= bitset[j]
+			} else {
+				blocks[j] = 0
+			}
+		}
+	}
+	return blocks
+}
+
+// Clears the index, so the file is indexed from its start on the next update
+func (idx *LogIndex) reset(info os.FileInfo) {
+	idx.blocks = make([]Range, 0)
+	idx.trigrams = make(map[uint32][]uint64)
+	idx.indexedEnd = 0
+	idx.fileInfo = info
+	idx.boundary = nil
+}
+
+// Returns true if the file is the one that was indexed and was only appended to since
+func (idx *LogIndex) isPrefixOf(file *os.File, info os.FileInfo) bool {
+	if idx.indexedEnd == 0 {
+		return true
+	}
+	if idx.fileInfo == nil || !os.SameFile(idx.fileInfo, info) || info.Size() < idx.indexedEnd {
+		return false
+	}
+	return bytes.Equal(readBoundary(file, idx.indexedEnd), idx.boundary)
+}
+
+func (idx *LogIndex) addBlock(block Range, blockTrigrams map[uint32]struct{}) {
+	blockNum := len(idx.blocks)
+	idx.blocks = append(idx.blocks, block)
+	for trigram := range blockTrigrams {
+		bitset := idx.trigrams[trigram]
+		for len(bitset) <= blockNum/64 {
+			bitset = append(bitset, 0)
+		}
+		bitset[blockNum/64] |= 1 << (blockNum % 64)
+		idx.trigrams[trigram] = bitset
+	}
+	idx.indexedEnd = block.End
+}
+
+// Adds the trigrams of the line (lower cased) to the set
+func addTrigrams(set map[uint32]struct{}, line []byte) {
+	lower := bytes.ToLower(bytes.TrimRight(line, "\r\n"))
+	for i := 0; i+TRIGRAM_SIZE <= len(lower); i++ {
+		set[trigramAt(lower, i)] = struct{}{}
+	}
+}
+
+func trigramAt(b []byte, i int) uint32 {
+	return uint32(b[i])<<16 | uint32(b[i+1])<<8 | uint32(b[i+2])
+}
+
+// Appends the part of the range in [start, end) to the ranges, joining it with the last range if they touch
+func appendClipped(ranges []Range, r Range, start int64, end int64) []Range {
+	if r.Start < start {
+		r.Start = start
+	}
+	if r.End > end {
+		r.End = end
+	}
+	if r.Start >= r.End {
+		return ranges
+	}
+	if len(ranges) > 0 && ranges[len(ranges)-1].End == r.Start {
+		ranges[len(ranges)-1].End = r.End
+		return ranges
+	}
+	return append(ranges, r)
+}
+
+// Reads the BOUNDARY_CHECK_SIZE bytes (or less at the start of the file) that end at offset
+func readBoundary(file *os.File, offset int64) []byte {
+	start := offset - BOUNDARY_CHECK_SIZE
+	if start < 0 {
+		start = 0
+	}
+	buff := make([]byte, offset-start)
+	if _, err := file.ReadAt(buff, start); err != nil && err != io.EOF {
+		return nil
+	}
+	return buff
+}
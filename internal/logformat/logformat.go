@@ -0,0 +1,223 @@
+package logformat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parser splits a log line into named fields, ex: "time", "level" and "msg".
+// Returns false if the line is not in the parser's format
+type Parser interface {
+	Parse(line string) (map[string]string, bool)
+}
+
+// Format of the lines in our logs, ex: "2023-09-06 22:52:35,317 INFO: Cache cleared".
+// The timestamp is optional since some of our logs (ex: data/test1.log) don't have one
+const DEFAULT_PATTERN = `^(?:(?P<time>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:,\d{3})?) )?(?P<level>[A-Z]+): (?P<msg>.*)$`
+
+// NewParser creates the parser for a log format given as:
+//   - "default": lines like "2023-09-06 22:52:35,317 INFO: message" (fields time, level and msg)
+//   - "json": one JSON object per line, with each top level key as a field
+//   - "logfmt": lines of key=value pairs, ex: `time=2023-09-06T22:52:35Z level=info msg="Cache cleared"`
+//   - "regex:<pattern>": a regular expression whose named groups are the fields, ex: `regex:^(?P<level>\w+) (?P<msg>.*)$`
+func NewParser(format string) (Parser, error) {
+	switch {
+	case format == "" || format == "default":
+		return NewRegexParser(DEFAULT_PATTERN)
+	case format == "json":
+		return &JSONParser{}, nil
+	case format == "logfmt":
+		return &LogfmtParser{}, nil
+	case strings.HasPrefix(format, "regex:"):
+		return NewRegexParser(strings.TrimPrefix(format, "regex:"))
+	}
+	return nil, fmt.Errorf("Unknown log format %q - must be default, json, logfmt or regex:<pattern>", format)
+}
+
+// RegexParser parses lines with a regular expression, using its named groups as the fields
+type RegexParser struct {
+	regex *regexp.Regexp
+	names []string
+}
+
+// NewRegexParser creates a RegexParser. Returns an error if the pattern is invalid or has no named groups
+func NewRegexParser(pattern string) (*RegexParser, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid log format regex: %v", err)
+	}
+	hasNamedGroup := false
+	for _, name := range regex.SubexpNames() {
+		hasNamedGroup = hasNamedGroup || name != ""
+	}
+	if !hasNamedGroup {
+		return nil, errors.New("Invalid log format regex: must have at least one named group, ex: (?P<level>[A-Z]+)")
+	}
+	return &RegexParser{regex: regex, names: regex.SubexpNames()}, nil
+}
+
+func (p *RegexParser) Parse(line string) (map[string]string, bool) {
+	match := p.regex.FindStringSubmatchIndex(line)
+	if match == nil {
+		return nil, false
+	}
+	fields := make(map[string]string)
+	for i, name := range p.names {
+		if name != "" && match[2*i] != -1 { // skip optional groups that did not participate
+			fields[name] = line[match[2*i]:match[2*i+1]]
+		}
+	}
+	return fields, true
+}
+
+// JSONParser parses lines that are JSON objects. Values that are not strings are stored as their JSON text
+type JSONParser struct{}
+
+func (p *JSONParser) Parse(line string) (map[string]string, bool) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &object); err != nil {
+		return nil, false
+	}
+	fields := make(map[string]string, len(object))
+	for key, raw := range object {
+		var str string
+		if err := json.Unmarshal(raw, &str); err == nil {
+			fields[key] = str
+		} else {
+			fields[key] = string(raw)
+		}
+	}
+	return fields, true
+}
+
+// LogfmtParser parses lines of space separated key=value pairs. Values can be double quoted (with
+// backslash escapes), and a key without "=" is stored with an empty value
+type LogfmtParser struct{}
+
+func (p *LogfmtParser) Parse(line string) (map[string]string, bool) {
+	fields := make(map[string]string)
+	i := 0
+	for i < len(line) {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+
+		keyStart := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[keyStart:i]
+		if key == "" {
+			return nil, false
+		}
+		if i >= len(line) || line[i] == ' ' {
+			fields[key] = ""
+			continue
+		}
+		i++ // skip "="
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, false // unterminated quote
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			fields[key] = value
+			i = end + 1
+		} else {
+			valueStart := i
+			for i < len(line) && line[i] != ' ' {
+				i++
+			}
+			fields[key] = line[valueStart:i]
+		}
+	}
+	return fields, len(fields) > 0
+}
+
+// Layouts of the timestamps that can be parsed from the "time" field of a log line
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05,000",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+}
+
+// Layouts of the time bounds a user can give, ex: --since "2023-09-06 22:50". Time of day only bounds are handled separately
+var boundLayouts = append(append([]string{}, timestampLayouts...), "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02")
+
+// Layouts of time of day only bounds, ex: --since 22:50
+var timeOfDayLayouts = []string{"15:04:05", "15:04"}
+
+// ParseTimestamp parses the timestamp of a log line (the "time" field of its parsed fields).
+// Timestamps w/o a time zone are in UTC. Returns false if the timestamp is not in a known layout
+func ParseTimestamp(value string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		if ts, err := time.Parse(layout, value); err == nil {
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ParseTimeBound parses a time bound given by the user, either a date with an optional time
+// (ex: "2023-09-06 22:50", in the same time zone as the logs) or only a time of day (ex: "22:50").
+// For a time of day, the returned time is that time on 0000-01-01 and timeOfDayOnly is true,
+// so the caller must move it to the date it applies to with OnDate(). precision is how long the bound lasts,
+// ex: a day for "2023-09-06" and a minute for "22:50", so that an upper bound can include all of it
+func ParseTimeBound(value string) (bound time.Time, precision time.Duration, timeOfDayOnly bool, err error) {
+	for _, layout := range boundLayouts {
+		if bound, err = time.Parse(layout, value); err == nil {
+			return bound, boundPrecision(layout, value), false, nil
+		}
+	}
+	for _, layout := range timeOfDayLayouts {
+		if bound, err = time.Parse(layout, value); err == nil {
+			return bound, boundPrecision(layout, value), true, nil
+		}
+	}
+	return time.Time{}, 0, false, fmt.Errorf("Invalid time %q - must be like \"2023-09-06 22:50:00\", \"2023-09-06\" or \"22:50\"", value)
+}
+
+// Helper function to get the precision of a time bound parsed with the layout, from the smallest unit it gives
+func boundPrecision(layout string, value string) time.Duration {
+	if !strings.Contains(layout, "15") {
+		return 24 * time.Hour
+	}
+	if !strings.Contains(layout, "05") {
+		return time.Minute
+	}
+	precision := time.Second
+	if i := strings.LastIndexAny(value, ".,"); i != -1 { // one tenth of the precision per digit of the fraction
+		for _, c := range value[i+1:] {
+			if c < '0' || c > '9' || precision == time.Nanosecond {
+				break
+			}
+			precision /= 10
+		}
+	}
+	return precision
+}
+
+// OnDate returns the time of day of timeOfDay on the date of date
+func OnDate(timeOfDay time.Time, date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), timeOfDay.Hour(), timeOfDay.Minute(), timeOfDay.Second(), timeOfDay.Nanosecond(), date.Location())
+}
@@ -0,0 +1,182 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Encoding of compressed frames. Receivers advertise the encodings they accept (ex: in GrepQuery.AcceptEncoding),
+// and senders only compress frames for receivers that accept it, so peers running older versions get plain frames
+const ENCODING_GZIP = "gzip"
+
+// Frames whose data is smaller than this many bytes are sent uncompressed by default, since they barely shrink
+const DEFAULT_COMPRESSION_THRESHOLD = 1024
+
+// Bit of the size of a frame set if its data is compressed. Frames are never that large (see MAX_FRAME_SIZE),
+// so an older version of the protocol never sets it
+const COMPRESSED_FLAG = 1 << 31
+
+// Largest data a frame can hold, since larger sizes would set COMPRESSED_FLAG. Also the most bytes a compressed frame
+// is decompressed to, so a small frame can't make the receiver allocate an unbounded amount of memory
+const MAX_FRAME_SIZE = COMPRESSED_FLAG - 1
+
+// Error returned when sending or receiving a frame larger than it can be
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// Sizes of a frame sent or received
+type FrameInfo struct {
+	Size       int // bytes of the data
+	WireSize   int // bytes of the data as sent over the connection, after compression
+	Compressed bool
+}
+
+/*
+Sends the data in a frame like SendRequest(), compressed with gzip if it is at least threshold bytes
+(and compressing it makes it smaller). The receiver must accept ENCODING_GZIP.
+Format: [size | COMPRESSED_FLAG][gzip data]
+*/
+func SendCompressed(data []byte, conn net.Conn, threshold int) (FrameInfo, error) {
+	info := FrameInfo{Size: len(data), WireSize: len(data)}
+	if len(data) < threshold {
+		return info, SendRequest(data, conn)
+	}
+
+	var compressed bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&compressed, gzip.BestSpeed) // only fails for an invalid level
+	if _, err := writer.Write(data); err != nil {
+		return info, err
+	}
+	if err := writer.Close(); err != nil {
+		return info, err
+	}
+	if compressed.Len() >= len(data) {
+		return info, SendRequest(data, conn)
+	}
+
+	if int64(compressed.Len()) > MAX_FRAME_SIZE {
+		return info, ErrFrameTooLarge
+	}
+	info.WireSize, info.Compressed = compressed.Len(), true
+	if err := sendMessageSize(compressed.Len()|COMPRESSED_FLAG, conn, MESSAGE_SIZE_BYTES); err != nil {
+		return info, err
+	}
+	_, err := conn.Write(compressed.Bytes())
+	return info, err
+}
+
+/*
+Reads a frame sent with SendRequest() or SendCompressed() from the connection, decompressing its data if it was
+compressed, and returns the data with the sizes of the frame
+*/
+func ReadFrame(reader *bufio.Reader) ([]byte, FrameInfo, error) {
+	return ReadFrameLimit(reader, MAX_FRAME_SIZE)
+}
+
+/*
+Reads a frame like ReadFrame(), but returns ErrFrameTooLarge if its data is (or decompresses to) more than maxSize bytes.
+The rest of the frame isn't read, so the connection can't be used for other frames after that
+*/
+func ReadFrameLimit(reader *bufio.Reader, maxSize int) ([]byte, FrameInfo, error) {
+	size, err := readMessageSize(reader, MESSAGE_SIZE_BYTES)
+	if err != nil {
+		return nil, FrameInfo{}, err
+	}
+	compressed := size&COMPRESSED_FLAG != 0
+	size &^= COMPRESSED_FLAG
+	if size > maxSize {
+		return nil, FrameInfo{}, ErrFrameTooLarge
+	}
+
+	buff := make([]byte, size)
+	if _, err = io.ReadFull(reader, buff); err != nil {
+		return nil, FrameInfo{}, err
+	}
+	if !compressed {
+		return buff, FrameInfo{Size: size, WireSize: size}, nil
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(buff))
+	if err != nil {
+		return nil, FrameInfo{}, fmt.Errorf("invalid compressed frame: %v", err)
+	}
+	// read 1 byte past the limit to tell a frame of exactly maxSize bytes from a larger one
+	data, err := io.ReadAll(io.LimitReader(gzipReader, int64(maxSize)+1))
+	if err != nil {
+		return nil, FrameInfo{}, fmt.Errorf("invalid compressed frame: %v", err)
+	}
+	if len(data) > maxSize {
+		return nil, FrameInfo{}, ErrFrameTooLarge
+	}
+	return data, FrameInfo{Size: len(data), WireSize: size, Compressed: true}, nil
+}
+
+// Stats of the frames sent and received, ex: to report the bytes saved by compression
+type Stats struct {
+	FramesSent           int64
+	FramesSentCompressed int64
+	BytesSent            int64 // bytes of the data of the frames sent
+	WireBytesSent        int64 // bytes of the frames sent over the network, after compression
+	FramesReceived       int64
+	FramesRecvCompressed int64
+	BytesReceived        int64 // bytes of the data of the frames received, after decompression
+	WireBytesReceived    int64 // bytes of the frames received over the network
+}
+
+// Formats the stats as a string
+func (s Stats) ToString() string {
+	strFormat := "Frames Sent: %d (%d compressed)\nBytes Sent: %d (%d on the wire, %s saved)\n" +
+		"Frames Received: %d (%d compressed)\nBytes Received: %d (%d on the wire, %s saved)\n"
+	return fmt.Sprintf(strFormat, s.FramesSent, s.FramesSentCompressed, s.BytesSent, s.WireBytesSent,
+		savedPercent(s.BytesSent, s.WireBytesSent), s.FramesReceived, s.FramesRecvCompressed, s.BytesReceived,
+		s.WireBytesReceived, savedPercent(s.BytesReceived, s.WireBytesReceived))
+}
+
+func savedPercent(size int64, wireSize int64) string {
+	if size == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(size-wireSize)/float64(size))
+}
+
+// StatsRecorder adds up the stats of the frames sent and received. Safe for concurrent use
+type StatsRecorder struct {
+	mutex sync.Mutex
+	stats Stats
+}
+
+// Adds a frame that was sent to the stats
+func (r *StatsRecorder) RecordSent(info FrameInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.FramesSent++
+	if info.Compressed {
+		r.stats.FramesSentCompressed++
+	}
+	r.stats.BytesSent += int64(info.Size)
+	r.stats.WireBytesSent += int64(info.WireSize)
+}
+
+// Adds a frame that was received to the stats
+func (r *StatsRecorder) RecordReceived(info FrameInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.FramesReceived++
+	if info.Compressed {
+		r.stats.FramesRecvCompressed++
+	}
+	r.stats.BytesReceived += int64(info.Size)
+	r.stats.WireBytesReceived += int64(info.WireSize)
+}
+
+// Returns a snapshot of the stats
+func (r *StatsRecorder) Stats() Stats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
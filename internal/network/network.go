@@ -15,10 +15,16 @@ Send request in the format
 Format: [size][data]
 
 	[size] is the size of the data represented in a binary format - 4 Byte big-endian
-	[data] is a []byte of the serialize GrepQuery/GrepOutput object gquery (use grep.SerializeGrepOutput())
+	[data] is a []byte of the serialize GrepQuery/GrepOutput object gquery (use grep.SerializeGrepOutput()),
+	a JSON object documented in docs/protocol.md
+
+Returns ErrFrameTooLarge w/o sending anything if the data is larger than MAX_FRAME_SIZE
 */
 func SendRequest(data []byte, conn net.Conn) error {
 	size := len(data)
+	if int64(size) > MAX_FRAME_SIZE {
+		return ErrFrameTooLarge
+	}
 	err := sendMessageSize(size, conn, MESSAGE_SIZE_BYTES)
 	if err != nil {
 		return err
@@ -37,25 +43,12 @@ Read request from connection. Reads the message size and correctly gets all the
 and returns it. Caller is expected to deserialize this []byte of data as this function does not
 do that.
 
-Returns an error of io.EOF or io.ErrUnexpectedEOF
+Returns an error of io.EOF or io.ErrUnexpectedEOF (or if a compressed frame is corrupted)
 */
 func ReadRequest(reader *bufio.Reader) ([]byte, error) {
-	data_size, err := readMessageSize(reader, MESSAGE_SIZE_BYTES)
-
-	if err != nil {
-		return nil, err
-	}
-	buff := make([]byte, data_size)
-
-	// ReadFull() reads exactly len(buff) bytes from reader into buff
-	// returns the number of bytes copied, and an error if fewer than len(buff) bytes were read
-	// the error is io.EOF only if no bytes were read. otherwise its an ErrUnexpectedEOF
-	_, err = io.ReadFull(reader, buff)
-	if err != nil { // fewer than len(buff) bytes were read, or none at all
-		return nil, err
-	}
-
-	return buff, nil
+	// frames sent compressed with SendCompressed() are decompressed (see ReadFrame())
+	data, _, err := ReadFrame(reader)
+	return data, err
 }
 
 /*
@@ -0,0 +1,98 @@
+package rpc
+
+import (
+	"context"
+	"cs425_mp1/internal/grep"
+	"errors"
+	"io"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client of the LogQuery service of one machine. The connection is established on the first call and
+// re-established if it breaks, so a client can be created before the machine is up. Safe for concurrent use
+type Client struct {
+	addr string
+	conn *grpc.ClientConn
+}
+
+// Creates a client of the machine listening on addr, ex: "fa23-cs425-1901.cs.illinois.edu:9001"
+func NewClient(addr string) (*Client, error) {
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CODEC_NAME)))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{addr: addr, conn: conn}, nil
+}
+
+// Returns the address of the machine
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Runs the query on the machine's log file and returns its output, put back together from the stream of results.
+// Returns an error if the call failed, ex: the machine is down or the deadline of ctx passed
+func (c *Client) Query(ctx context.Context, cmdArgs []string) (*grep.GrepOutput, error) {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+SERVICE_NAME+"/Query")
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SendMsg(&QueryRequest{CmdArgs: cmdArgs}); err != nil {
+		return nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	var lines strings.Builder
+	var records []grep.LogRecord
+	var gOut *grep.GrepOutput
+	for {
+		result := new(QueryResult)
+		err = stream.RecvMsg(result)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		lines.WriteString(result.Lines)
+		if result.Records != nil {
+			records = append(records, result.Records...)
+		}
+		if result.Output != nil {
+			gOut = result.Output
+		}
+	}
+	if gOut == nil {
+		return nil, errors.New("stream ended w/o the output")
+	}
+	gOut.Output, gOut.Records = lines.String(), records
+	return gOut, nil
+}
+
+// Returns the usage stats of the machine
+func (c *Client) Stats(ctx context.Context) (*StatsReply, error) {
+	reply := new(StatsReply)
+	if err := c.conn.Invoke(ctx, "/"+SERVICE_NAME+"/Stats", &StatsRequest{}, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Returns whether the machine can serve queries
+func (c *Client) Health(ctx context.Context) (*HealthReply, error) {
+	reply := new(HealthReply)
+	if err := c.conn.Invoke(ctx, "/"+SERVICE_NAME+"/Health", &HealthRequest{}, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Closes the connection to the machine
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
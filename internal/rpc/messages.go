@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"cs425_mp1/internal/cache"
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/index"
+	"cs425_mp1/internal/network"
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// Name of the codec messages are sent with, i.e. the content type "application/grpc+json"
+const CODEC_NAME = "json"
+
+// Max number of bytes of output lines sent in one QueryResult, so that every result is far below gRPC's default max
+// message size of 4 MB however large the output is. Chunks end on a line boundary, so a chunk can be larger if
+// a single line is
+const STREAM_CHUNK_SIZE = 64 * 1024
+
+// Statuses of HealthReply
+const (
+	STATUS_SERVING     = "SERVING"
+	STATUS_NOT_SERVING = "NOT_SERVING"
+)
+
+// The messages of the LogQuery service, see docs/protocol.md for their JSON form
+
+type QueryRequest struct {
+	CmdArgs []string // grep command w/o the filename, ex: ["grep", "-c", "ERROR"]
+}
+
+type QueryResult struct {
+	Lines   string           // chunk of the output lines, to append to the ones received before
+	Records []grep.LogRecord // records of the lines of the chunk, with --where
+	Output  *grep.GrepOutput // only set on the last result, with the output lines and records left out
+}
+
+type StatsRequest struct{}
+
+type StatsReply struct {
+	Machine   string
+	Cache     cache.Stats
+	DiskCache *cache.Stats // nil if the machine has no disk cache
+	Network   network.Stats
+	Index     *index.Stats // nil if the machine doesn't index its log file
+}
+
+type HealthRequest struct{}
+
+type HealthReply struct {
+	Machine      string
+	Status       string // STATUS_SERVING or STATUS_NOT_SERVING
+	Error        string // why the machine is not serving
+	LogFileBytes int64
+}
+
+// Codec that sends the messages as JSON, so that the service can be used w/o code generated by protoc
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return CODEC_NAME
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"context"
+	"cs425_mp1/internal/grep"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// Full name of the service (see docs/protocol.md)
+const SERVICE_NAME = "logquery.LogQuery"
+
+// LogQueryServer is implemented by every machine to serve the LogQuery service (see RegisterLogQueryServer())
+type LogQueryServer interface {
+	// Runs the query on the machine's log file only and sends its output with SendOutput()
+	Query(request *QueryRequest, stream QueryStream) error
+	Stats(ctx context.Context, request *StatsRequest) (*StatsReply, error)
+	Health(ctx context.Context, request *HealthRequest) (*HealthReply, error)
+}
+
+// Server side of the stream of results of a Query call
+type QueryStream interface {
+	Send(result *QueryResult) error
+	Context() context.Context
+}
+
+// Registers the implementation of the LogQuery service with the gRPC server
+func RegisterLogQueryServer(s *grpc.Server, srv LogQueryServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// Sends the output on the stream: its lines in chunks of about STREAM_CHUNK_SIZE with the records of their lines,
+// then the rest of the output. Stops early if the call was cancelled or its deadline passed
+func SendOutput(stream QueryStream, gOut *grep.GrepOutput) error {
+	lines := gOut.Output
+	var records []grep.LogRecord
+	if len(gOut.Records) == gOut.NumLines { // every line has a record, with --where
+		records = gOut.Records
+	}
+	for len(lines) > STREAM_CHUNK_SIZE {
+		end := strings.LastIndexByte(lines[:STREAM_CHUNK_SIZE], '\n') + 1
+		if end == 0 { // the first line is larger than a chunk, so it is a chunk of its own
+			end = strings.IndexByte(lines, '\n') + 1
+		}
+		if end == 0 {
+			break
+		}
+		result := &QueryResult{Lines: lines[:end]}
+		if records != nil {
+			n := strings.Count(result.Lines, "\n")
+			result.Records, records = records[:n], records[n:]
+		}
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+		if err := stream.Send(result); err != nil {
+			return err
+		}
+		lines = lines[end:]
+	}
+
+	last := *gOut // gOut may be shared, so leave out the lines on a copy
+	last.Output, last.Records = "", nil
+	return stream.Send(&QueryResult{Lines: lines, Records: records, Output: &last})
+}
+
+// Written by hand instead of generated by protoc, since the messages are plain Go structs sent as JSON
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: SERVICE_NAME,
+	HandlerType: (*LogQueryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Stats", Handler: statsHandler},
+		{MethodName: "Health", Handler: healthHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Query", Handler: queryHandler, ServerStreams: true},
+	},
+	Metadata: "docs/protocol.md",
+}
+
+type queryServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *queryServerStream) Send(result *QueryResult) error {
+	return s.ServerStream.SendMsg(result)
+}
+
+func queryHandler(srv any, stream grpc.ServerStream) error {
+	request := new(QueryRequest)
+	if err := stream.RecvMsg(request); err != nil {
+		return err
+	}
+	return srv.(LogQueryServer).Query(request, &queryServerStream{stream})
+}
+
+func statsHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	request := new(StatsRequest)
+	if err := dec(request); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(LogQueryServer).Stats(ctx, req.(*StatsRequest))
+	}
+	if interceptor == nil {
+		return handler(ctx, request)
+	}
+	return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + SERVICE_NAME + "/Stats"}, handler)
+}
+
+func healthHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	request := new(HealthRequest)
+	if err := dec(request); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(LogQueryServer).Health(ctx, req.(*HealthRequest))
+	}
+	if interceptor == nil {
+		return handler(ctx, request)
+	}
+	return interceptor(ctx, request, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + SERVICE_NAME + "/Health"}, handler)
+}
@@ -0,0 +1,22 @@
+package webui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The page and everything it uses are embedded in the binary, so any machine can serve it w/o extra files
+//
+//go:embed static
+var static embed.FS
+
+// Returns the handler serving the web UI. The page runs queries with the HTTP API of the machine that serves it
+// (see distributed_engine.HttpHandler())
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil { // can't happen, the directory is embedded
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
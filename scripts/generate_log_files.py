@@ -1,5 +1,6 @@
 import logging
 import random
+import sys
 import time
 
 # randomly pick between info, error, debug, and warning
@@ -184,6 +185,12 @@ def add_known_lines(known_lines, logger):
 
 def main():
 
+    # python generate_log_files.py <file> <num_lines> generates one big timed log file, ex: to benchmark the index
+    if len(sys.argv) == 3:
+        generate_log_file(sys.argv[1], int(sys.argv[2]), True, [], 0)
+        logging.shutdown()
+        return
+
     files = ['test_log_file4.log', 'test_log_file5.log', 'test_log_file6.log']
     file_lines = [15, 28, 36]
     known_lines = [[(logging.ERROR, "File not found: 'file.txt'"),
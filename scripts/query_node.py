@@ -0,0 +1,49 @@
+# Queries one machine's log file directly, ex: python query_node.py fa23-cs425-1901.cs.illinois.edu:8001 grep -c ERROR
+# See docs/protocol.md for the format of the queries and outputs
+import gzip
+import json
+import socket
+import struct
+import sys
+
+COMPRESSED_FLAG = 1 << 31
+
+
+def read_exactly(sock, size):
+    data = b""
+    while len(data) < size:
+        chunk = sock.recv(size - len(data))
+        if not chunk:
+            raise ConnectionError("connection closed by the machine")
+        data += chunk
+    return data
+
+
+def query_node(address, cmd_args):
+    host, port = address.rsplit(":", 1)
+    with socket.create_connection((host, int(port))) as sock:
+        query = json.dumps({"CmdArgs": cmd_args, "AcceptEncoding": "gzip"}).encode("utf-8")
+        sock.sendall(struct.pack(">I", len(query)) + query)
+
+        (size,) = struct.unpack(">I", read_exactly(sock, 4))
+        data = read_exactly(sock, size & ~COMPRESSED_FLAG)
+        if size & COMPRESSED_FLAG:
+            data = gzip.decompress(data)
+        return json.loads(data)
+
+
+def main():
+    if len(sys.argv) < 4 or sys.argv[2] != "grep":
+        print("Usage: python query_node.py <host:port> grep <args...>")
+        sys.exit(2)
+
+    output = query_node(sys.argv[1], sys.argv[2:])
+    if output["ExitStatus"] == 2:
+        print("Error on %s: %s" % (output["Machine"], output["Error"]), file=sys.stderr)
+        sys.exit(2)
+    print(output["Output"], end="")
+    sys.exit(output["ExitStatus"])
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"cs425_mp1/internal/grep"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAggregateAcrossMachines(t *testing.T) {
+	dir := t.TempDir()
+	logFile1 := filepath.Join(dir, "vm1.log")
+	logFile2 := filepath.Join(dir, "vm2.log")
+	_ = os.WriteFile(logFile1, []byte(strings.Join([]string{
+		"2023-09-06 22:52:05,000 ERROR: Disk full",
+		"2023-09-06 22:52:45,000 ERROR: Disk full",
+		"2023-09-06 22:53:10,000 INFO: Recovered",
+		"2023-09-06 22:53:20,000 ERROR: Timeout",
+	}, "\n")+"\n"), 0644)
+	_ = os.WriteFile(logFile2, []byte(strings.Join([]string{
+		"2023-09-06 22:52:30,000 ERROR: Timeout",
+		"2023-09-06 22:53:00,000 ERROR: Disk full",
+	}, "\n")+"\n"), 0644)
+
+	outputsFor := func(input string) (*grep.GrepQuery, []grep.GrepOutput) {
+		q, err := grep.CreateGrepQueryFromInput(input)
+		if err != nil {
+			t.Fatalf("Error for %s: %v", input, err)
+		}
+		out1, out2 := q.Execute(logFile1), q.Execute(logFile2)
+		out1.Machine, out2.Machine = "vm1", "vm2"
+		if out1.Output != "" || out1.Records != nil {
+			t.Errorf("%s: expected only counts to be sent back, but got %q", input, out1.Output)
+		}
+		return q, []grep.GrepOutput{*out1, *out2}
+	}
+
+	q, outputs := outputsFor(`grep ERROR --count-by time/1m,machine`)
+	expected := []grep.AggregateCount{
+		{Keys: []string{"2023-09-06 22:52:00", "vm1"}, Count: 2},
+		{Keys: []string{"2023-09-06 22:52:00", "vm2"}, Count: 1},
+		{Keys: []string{"2023-09-06 22:53:00", "vm1"}, Count: 1},
+		{Keys: []string{"2023-09-06 22:53:00", "vm2"}, Count: 1},
+	}
+	if combined := grep.CombineAggregates(q, outputs); !reflect.DeepEqual(combined, expected) {
+		t.Errorf("Expected %v, but got %v", expected, combined)
+	}
+	if outputs[0].NumLines != 3 {
+		t.Errorf("Expected 3 lines counted on vm1, but got %d", outputs[0].NumLines)
+	}
+
+	q, outputs = outputsFor(`grep --count-by msg --top 1`)
+	expected = []grep.AggregateCount{{Keys: []string{"Disk full"}, Count: 3}}
+	if combined := grep.CombineAggregates(q, outputs); !reflect.DeepEqual(combined, expected) {
+		t.Errorf("Expected %v, but got %v", expected, combined)
+	}
+
+	table := grep.FormatAggregates(q.AggregateKeys(), expected)
+	if !strings.HasPrefix(table, "msg        count") || !strings.Contains(table, "Disk full  3      ########") {
+		t.Errorf("Unexpected table:\n%s", table)
+	}
+
+	for _, input := range []string{
+		`grep ERROR --top 10`,
+		`grep ERROR --count-by level --top 0`,
+		`grep ERROR --count-by time/soon`,
+		`grep -c ERROR --count-by level`,
+		`grep -o ERROR --count-by level`,
+	} {
+		if _, err := grep.CreateGrepQueryFromInput(input); err == nil {
+			t.Errorf("Expected an error for %s", input)
+		}
+	}
+}
+
+func TestAggregateIncrementalMerge(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	prefix := "2023-09-06 22:52:05,000 ERROR: Disk full\n2023-09-06 22:52:10,000 INFO: Retrying\n"
+	_ = os.WriteFile(logFile, []byte(prefix+"2023-09-06 22:52:15,000 ERROR: Disk full\n"), 0644)
+
+	q, _ := grep.CreateGrepQueryFromInput(`grep --count-by level`)
+	if !q.SupportsIncrementalMerge() {
+		t.Fatalf("Expected aggregate queries to support incremental merges")
+	}
+	merged := q.MergeIncrementalOutputs(q.ExecuteRange(logFile, 0, int64(len(prefix))), q.ExecuteRange(logFile, int64(len(prefix)), int64(len(prefix))+41))
+	full := q.Execute(logFile)
+	if !reflect.DeepEqual(merged.Aggregates, full.Aggregates) || merged.NumLines != full.NumLines {
+		t.Errorf("Expected %v, but got %v", full.Aggregates, merged.Aggregates)
+	}
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"cs425_mp1/internal/cache"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsByBytes(t *testing.T) {
+	c, err := cache.New(cache.Config{MaxBytes: 100})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	c.Add("a", "a", 40)
+	c.Add("b", "b", 40)
+	c.Get("a") // a is now the most recently used, so b should be evicted first
+	c.Add("c", "c", 40)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("Expected a to still be cached")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Errorf("Expected c to still be cached")
+	}
+
+	stats := c.Stats()
+	if stats.Bytes != 80 || stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("Expected 80 bytes, 2 entries and 1 eviction, but got %d bytes, %d entries and %d evictions", stats.Bytes, stats.Entries, stats.Evictions)
+	}
+}
+
+func TestCacheEvictsByEntries(t *testing.T) {
+	c, _ := cache.New(cache.Config{MaxEntries: 2})
+	c.Add("a", "a", 1)
+	c.Add("b", "b", 1)
+	c.Add("c", "c", 1)
+
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected a to be evicted")
+	}
+	if c.Stats().Entries != 2 {
+		t.Errorf("Expected 2 entries, but got %d", c.Stats().Entries)
+	}
+}
+
+func TestCacheRejectsLargeEntries(t *testing.T) {
+	c, _ := cache.New(cache.Config{MaxBytes: 1000, MaxEntryBytes: 10})
+	c.Add("a", "old", 5)
+
+	if c.Add("a", "new", 11) {
+		t.Errorf("Expected entry larger than MaxEntryBytes to be rejected")
+	}
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected stale value of a rejected entry to be removed")
+	}
+	if c.Stats().Rejections != 1 {
+		t.Errorf("Expected 1 rejection, but got %d", c.Stats().Rejections)
+	}
+}
+
+func TestCacheExpiresEntries(t *testing.T) {
+	c, _ := cache.New(cache.Config{MaxEntries: 10, TTL: 10 * time.Millisecond})
+	c.Add("a", "a", 1)
+
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("Expected a to be cached before its TTL")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected a to be expired after its TTL")
+	}
+
+	stats := c.Stats()
+	if stats.Expirations != 1 || stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected 1 expiration and an empty cache, but got %d expirations, %d entries, %d bytes", stats.Expirations, stats.Entries, stats.Bytes)
+	}
+}
+
+func TestDiskCachePersistsAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+	d1, err := cache.NewDiskCache(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err = d1.Put("grep;-c;ERROR", []byte("11\n")); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	d2, err := cache.NewDiskCache(dir, 0, 0) // simulates a restart
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	data, _, ok := d2.Get("grep;-c;ERROR")
+	if !ok || string(data) != "11\n" {
+		t.Errorf("Expected persisted value %q, but got %q (found = %v)", "11\n", data, ok)
+	}
+}
+
+func TestDiskCacheIgnoresCorruptedFiles(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := cache.NewDiskCache(dir, 0, 0)
+	_ = d.Put("key", []byte("some cached output"))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 cache file, but found %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	data[len(data)-1] ^= 0xFF // flip the bits of the last byte
+	_ = os.WriteFile(files[0], data, 0644)
+
+	d, _ = cache.NewDiskCache(dir, 0, 0)
+	if _, _, ok := d.Get("key"); ok {
+		t.Errorf("Expected corrupted cache file to be treated as a miss")
+	}
+	if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected corrupted cache file to be deleted")
+	}
+}
+
+func TestDiskCacheExpiresFiles(t *testing.T) {
+	dir := t.TempDir()
+	d, _ := cache.NewDiskCache(dir, 0, 50*time.Millisecond)
+	_ = d.Put("key", []byte("some cached output"))
+
+	if _, addedAt, ok := d.Get("key"); !ok || time.Since(addedAt) > time.Second {
+		t.Errorf("Expected the file to be cached before its TTL, with the time it was stored")
+	}
+	time.Sleep(100 * time.Millisecond)
+	d, _ = cache.NewDiskCache(dir, 0, 50*time.Millisecond) // the age of the file survives restarts
+	if _, _, ok := d.Get("key"); ok {
+		t.Errorf("Expected the file to be expired after its TTL")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.cache")); len(files) != 0 || d.Stats().Expirations != 1 {
+		t.Errorf("Expected the expired file to be deleted, but found %d files", len(files))
+	}
+}
+
+func TestDiskCacheEvictsOverLimit(t *testing.T) {
+	d, _ := cache.NewDiskCache(t.TempDir(), 200, 0)
+	_ = d.Put("a", make([]byte, 100))
+	time.Sleep(10 * time.Millisecond)
+	_ = d.Put("b", make([]byte, 100))
+
+	if _, _, ok := d.Get("a"); ok {
+		t.Errorf("Expected least recently used file to be evicted")
+	}
+	if _, _, ok := d.Get("b"); !ok {
+		t.Errorf("Expected most recently used file to still be cached")
+	}
+}
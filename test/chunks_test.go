@@ -0,0 +1,85 @@
+package test
+
+import (
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/index"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestExecuteParallelChunks(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	writeBenchLog(t, logFile, 1)
+	info, _ := os.Stat(logFile)
+	size := info.Size()
+	sequential := grep.NewExecutor(nil)
+	parallel := grep.NewExecutor(nil)
+	parallel.SetParallelism(4, 64*1024)
+	indexedParallel := grep.NewExecutor(nil)
+	indexedParallel.SetParallelism(3, 10000) // chunks not on the boundaries of the index blocks
+	indexedParallel.SetIndex(index.New(logFile, 4096))
+
+	inputs := []string{
+		`grep "Disk failure"`,
+		`grep -c ERROR`,
+		`grep -c -i "database QUERY"`,
+		`grep -o "node [0-9]*"`,
+		`grep -v INFO`,
+		`grep --where 'level=CRITICAL AND msg~"Disk"'`,
+		`grep -c --where level=ERROR`,
+		`grep --count-by level`,
+		`grep --expr 'Disk AND NOT node'`, // only queries with --expr are split, the others are grepped in one go
+		`grep -c -i --expr '"database QUERY" OR failure'`,
+		`grep -w --expr 'Disk AND NOT node' --where level=CRITICAL`,
+		`grep --expr 'ERROR OR CRITICAL' --count-by level`,
+		`grep Security --since "2023-09-06 00:00:05" --until "2023-09-06 00:00:09"`,
+		`grep -n "Disk failure"`, // not split since line numbers depend on the lines before
+		`grep -A1 "Disk failure"`,
+		`grep "no such line"`,
+	}
+	for _, input := range inputs {
+		q, err := grep.CreateGrepQueryFromInput(input)
+		if err != nil {
+			t.Fatalf("Error for %s: %v", input, err)
+		}
+		expected := sequential.ExecuteRange(q, logFile, 0, size)
+		for _, executor := range []*grep.Executor{parallel, indexedParallel} {
+			actual := executor.Execute(q, logFile)
+			if expected.Output != actual.Output || expected.MatchCount != actual.MatchCount || expected.NumLines != actual.NumLines ||
+				expected.ExitStatus != actual.ExitStatus || !reflect.DeepEqual(expected.Aggregates, actual.Aggregates) {
+				t.Errorf("%s: expected %d matches in parallel like sequentially, but got %d", input, expected.MatchCount, actual.MatchCount)
+			}
+		}
+	}
+
+	// an error in a chunk is the error of the query
+	q, _ := grep.CreateGrepQueryFromInput(`grep -E "(a"`)
+	if gOut := parallel.Execute(q, logFile); !gOut.Failed() {
+		t.Errorf("Expected the invalid regex to fail, but got exit status %d", gOut.ExitStatus)
+	}
+}
+
+func benchmarkParallelQuery(b *testing.B, workers int) {
+	logFile := benchLogFile(b)
+	executor := grep.NewExecutor(nil)
+	executor.SetParallelism(workers, 0)
+	q, _ := grep.CreateGrepQueryFromInput(`grep -c --expr '"Database query timeout"'`)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if gOut := executor.Execute(q, logFile); gOut.Failed() {
+			b.Fatalf("Query failed: %s", gOut.Error)
+		}
+	}
+}
+
+func BenchmarkQuerySequential(b *testing.B) {
+	benchmarkParallelQuery(b, 1)
+}
+
+func BenchmarkQueryParallel(b *testing.B) {
+	benchmarkParallelQuery(b, runtime.NumCPU())
+}
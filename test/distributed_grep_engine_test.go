@@ -1,47 +1,55 @@
 package test
 
 import (
+	"bufio"
+	"cs425_mp1/internal/cache"
 	"cs425_mp1/internal/distributed_engine"
 	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/network"
 	"log"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestCreatingJson(t *testing.T) {
 
-	packagedString := "grep;-w;sample"
+	packagedString := grep.PackageCmdArgs([]string{"grep", "-w", "sample"})
 
 	output1 := "Output file1"
 	filename1 := "sample_text_file1.txt"
 	numLines1 := 20
 	exectionTime1 := time.Duration(50)
-	grepOut1 := grep.GrepOutput{output1, filename1, numLines1, exectionTime1}
+	grepOut1 := grep.GrepOutput{Output: output1, Filename: filename1, NumLines: numLines1, ExecutionTime: exectionTime1}
 
 	output2 := "Output file2 from grep"
 	filename2 := "example_text_file2.txt"
 	numLines2 := 3
 	exectionTime2 := time.Duration(5)
-	grepOut2 := grep.GrepOutput{output2, filename2, numLines2, exectionTime2}
+	grepOut2 := grep.GrepOutput{Output: output2, Filename: filename2, NumLines: numLines2, ExecutionTime: exectionTime2}
 
 	output3 := "Output file3 from grep"
 	filename3 := "test_text_file3.txt"
 	numLines3 := 8
 	exectionTime3 := time.Duration(12)
-	grepOut3 := grep.GrepOutput{output3, filename3, numLines3, exectionTime3}
+	grepOut3 := grep.GrepOutput{Output: output3, Filename: filename3, NumLines: numLines3, ExecutionTime: exectionTime3}
 
 	outputs := []grep.GrepOutput{grepOut1, grepOut2, grepOut3}
 
-	engine := distributed_engine.CreateEngine("test", "8080", nil, 20, false, "test%d.json")
+	dir := t.TempDir()
+	engine := distributed_engine.CreateEngine("test", "8080", nil, cache.Config{MaxEntries: 20}, false, filepath.Join(dir, "test%d.json"))
 	_, err := engine.CreateJson(packagedString, outputs)
 
 	if err != nil {
 		t.Errorf("Failed to Create Json file")
 	}
 
-	query, outputs := distributed_engine.DeserializeJson("test1.json")
+	query, outputs := distributed_engine.DeserializeJson(filepath.Join(dir, "test1.json"))
 
 	if query != packagedString {
 		t.Errorf("Expected query %s but got %s", packagedString, query)
@@ -64,6 +72,32 @@ func TestCreatingJson(t *testing.T) {
 	}
 }
 
+// Tests that JSON files with queries packaged in the legacy format are migrated to the current format
+func TestMigrateLegacyJson(t *testing.T) {
+	jsonFile := filepath.Join(t.TempDir(), "legacy.json")
+	legacyData, _ := os.ReadFile("test_execute_data/expected/test1_expected.json")
+	_ = os.WriteFile(jsonFile, legacyData, 0644)
+
+	expectedQuery := grep.PackageCmdArgs([]string{"grep", "-c", "GET"})
+	query, outputs := distributed_engine.DeserializeJson(jsonFile)
+	if query != expectedQuery {
+		t.Errorf("Expected legacy query to be read as %s but got %s", expectedQuery, query)
+	}
+
+	changed, err := distributed_engine.MigrateJsonFile(jsonFile)
+	if err != nil || !changed {
+		t.Fatalf("Expected legacy file to be migrated (changed = %v, err = %v)", changed, err)
+	}
+	migratedQuery, migratedOutputs := distributed_engine.DeserializeJson(jsonFile)
+	if migratedQuery != expectedQuery || len(migratedOutputs) != len(outputs) {
+		t.Errorf("Expected migrated file to have query %s and %d outputs", expectedQuery, len(outputs))
+	}
+
+	if changed, _ = distributed_engine.MigrateJsonFile(jsonFile); changed {
+		t.Errorf("Expected migrated file to not change when migrated again")
+	}
+}
+
 /*
 Tests running one grep query with 3 VMs and seeing if the outputs are correct.
 Only tests one query since each query is independent of each other and don't have any effect
@@ -126,9 +160,144 @@ func TestExecuteCaching(t *testing.T) {
 
 	// evaluate the execution time
 	for i := 0; i < len(actual_gOut1); i++ {
-		// we want gOut2 time to be less than gOut1 time. Otherwise, it's an error
-		if actual_gOut1[i].ExecutionTime < actual_gOut2[i].ExecutionTime {
-			t.Errorf("gOut1 Time (%d) >= gOut2 Time (%d)", actual_gOut1[i].ExecutionTime.Nanoseconds(), actual_gOut2[i].ExecutionTime.Nanoseconds())
+		if actual_gOut1[i].CacheHit || !actual_gOut2[i].CacheHit {
+			t.Errorf("Expected only gOut2 [%d] to be a cache hit", i)
+		}
+		// we want gOut2 cache lookup time to be less than gOut1 execution time. Otherwise, it's an error
+		if actual_gOut1[i].ExecutionTime < actual_gOut2[i].CacheLookupTime {
+			t.Errorf("gOut1 Time (%d) >= gOut2 Time (%d)", actual_gOut1[i].ExecutionTime.Nanoseconds(), actual_gOut2[i].CacheLookupTime.Nanoseconds())
+		}
+	}
+}
+
+// Tests that concurrent local queries on the same engine share cached outputs without modifying them.
+// Run with -race to check the engine for data races
+func TestExecuteLocalConcurrent(t *testing.T) {
+	engine := distributed_engine.CreateEngine("test_logs/test_log_file6.log", ":0", nil, cache.Config{MaxEntries: 10}, false, "")
+	inputs := []string{"grep -c CRITICAL:", "grep -ci critical:", "grep CRITICAL:", "grep -c INFO"}
+
+	first := make(map[string]*grep.GrepOutput)
+	for _, input := range inputs {
+		q, _ := grep.CreateGrepQueryFromInput(input)
+		first[input] = engine.ExecuteLocal(q)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		for _, input := range inputs {
+			wg.Add(1)
+			go func(input string) {
+				defer wg.Done()
+				q, _ := grep.CreateGrepQueryFromInput(input)
+				gOut := engine.ExecuteLocal(q)
+				if !gOut.CacheHit {
+					t.Errorf("Expected %q to be a cache hit", input)
+				}
+				if !grep.GrepOutputsAreEqual(gOut, first[input]) {
+					t.Errorf("Expected cached output of %q to match its first output", input)
+				}
+			}(input)
+		}
+	}
+	wg.Wait()
+
+	for input, gOut := range first {
+		if gOut.CacheHit || gOut.CacheLookupTime != 0 {
+			t.Errorf("Expected first output of %q to not be modified by cache hits", input)
+		}
+	}
+}
+
+// Tests that a last line w/o a trailing new line is grepped, and grepped again once the rest of it is written
+func TestExecuteLocalPartialLastLine(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	_ = os.WriteFile(logFile, []byte("INFO: Started\nWARN"), 0644)
+	engine := distributed_engine.CreateEngine(logFile, ":0", nil, cache.Config{MaxEntries: 10}, false, "")
+
+	for _, input := range []string{"grep WARN", "grep -c WARN"} {
+		q, _ := grep.CreateGrepQueryFromInput(input)
+		if gOut := engine.ExecuteLocal(q); gOut.MatchCount != 1 {
+			t.Errorf("%s: expected the partial last line to match, but got %q", input, gOut.Output)
+		}
+	}
+
+	file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = file.WriteString("ING: Disk almost full\nWARNING: Disk full\n")
+	_ = file.Close()
+
+	expected := map[string]string{
+		"grep WARN":    "WARNING: Disk almost full\nWARNING: Disk full\n",
+		"grep -c WARN": "2\n",
+	}
+	for input, output := range expected {
+		q, _ := grep.CreateGrepQueryFromInput(input)
+		if gOut := engine.ExecuteLocal(q); gOut.Output != output || gOut.MatchCount != 2 {
+			t.Errorf("%s: expected %q once the last line was complete, but got %q", input, output, gOut.Output)
+		}
+	}
+}
+
+func TestMergeOutputsByTime(t *testing.T) {
+	outputs := []grep.GrepOutput{
+		{Machine: "vm1", Filename: "vm1.log", Output: "2023-09-06 22:50:00,000 ERROR: Disk full\n" +
+			"    continuation of the disk full error\n" +
+			"2023-09-06 22:53:00,000 INFO: Recovered\n"},
+		{Machine: "vm2", Filename: "vm2.log", Output: ""},
+		{Machine: "vm3", Filename: "vm3.log", Output: "2023-09-06 22:49:00,000 WARNING: Low disk\n" +
+			"2023-09-06 22:50:00,000 ERROR: Replica lagging\n" +
+			"2023-09-06 22:51:00,000 INFO: Retrying\n"},
+	}
+	expected := "vm3:vm3.log: 2023-09-06 22:49:00,000 WARNING: Low disk\n" +
+		"vm1:vm1.log: 2023-09-06 22:50:00,000 ERROR: Disk full\n" +
+		"vm1:vm1.log:     continuation of the disk full error\n" +
+		"vm3:vm3.log: 2023-09-06 22:50:00,000 ERROR: Replica lagging\n" +
+		"vm3:vm3.log: 2023-09-06 22:51:00,000 INFO: Retrying\n" +
+		"vm1:vm1.log: 2023-09-06 22:53:00,000 INFO: Recovered\n"
+
+	if merged := distributed_engine.MergeOutputsByTime(outputs, grep.NewExecutor(nil)); merged != expected {
+		t.Errorf("Expected merged output:\n%s\nbut got:\n%s", expected, merged)
+	}
+}
+
+// Tests that a machine answers queries from clients that aren't peers: JSON clients like scripts/query_node.py,
+// and peers running an older version that send gob
+func TestServeJsonAndGobClients(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	_ = os.WriteFile(logFile, []byte("ERROR: Disk full\nINFO: Retrying\nERROR: Disk still full\n"), 0644)
+	engine := distributed_engine.CreateEngine(logFile, "127.0.0.1:18046", nil, cache.Config{MaxEntries: 10}, false, "")
+	engine.InitializeServer()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:18046")
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	query := func(data []byte) *grep.GrepOutput {
+		if err := network.SendRequest(data, conn); err != nil {
+			t.Fatalf("Failed to send query: %v", err)
+		}
+		outData, err := network.ReadRequest(reader)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		gOut, err := grep.DeserializeGrepOutput(outData)
+		if err != nil {
+			t.Fatalf("Failed to deserialize output %q: %v", outData, err)
+		}
+		return gOut
+	}
+
+	if gOut := query([]byte(`{"CmdArgs": ["grep", "-c", "ERROR"]}`)); gOut.Output != "2\n" || gOut.MatchCount != 2 {
+		t.Errorf("Expected a count of 2 for the JSON query, but got %q", gOut.Output)
+	}
+	gobQuery, _ := grep.SerializeGrepQueryGob(&grep.GrepQuery{CmdArgs: []string{"grep", "INFO"}})
+	if gOut := query(gobQuery); gOut.Output != "INFO: Retrying\n" {
+		t.Errorf("Expected the INFO line for the gob query, but got %q", gOut.Output)
+	}
+	for _, invalid := range []string{`{"CmdArgs": ["rm", "-rf", "/"]}`, `{"CmdArgs": ["grep", "--where", "level="]}`, `{"CmdArgs": 1}`} {
+		if gOut := query([]byte(invalid)); !gOut.Failed() || !strings.Contains(gOut.Error, "Invalid") {
+			t.Errorf("Expected %s to be rejected, but got exit status %d (%s)", invalid, gOut.ExitStatus, gOut.Error)
 		}
 	}
 }
@@ -2,6 +2,10 @@ package test
 
 import (
 	"cs425_mp1/internal/grep"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -168,7 +172,7 @@ func TestExecuteComplexRegEx1(t *testing.T) {
 	var expectedOutput = "CRITICAL: Server outage: emergency shutdown\nERROR: Internal server error: Unable to process request\nERROR: File not found: 'file.txt'\nCRITICAL: Application halted: fatal error\n"
 	var expectedNumLines = 4
 
-	q, err := grep.CreateGrepQueryFromInput("grep ^ERROR\\|^CRITICAL")
+	q, err := grep.CreateGrepQueryFromInput("grep '^ERROR\\|^CRITICAL'")
 
 	if err != nil {
 		t.Errorf("Error: %v", err)
@@ -296,3 +300,257 @@ func TestSerializeDeserializeQuery(t *testing.T) {
 		}
 	}
 }
+
+// Tests that grepping a file in two ranges and merging the outputs gives the same output as grepping it all at once
+func TestExecuteRangeIncrementalMerge(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "append.log")
+	prefix := "2023-09-06 22:52:35,317 ERROR: Database query timeout\n2023-09-06 22:52:35,318 INFO: Cache cleared\n"
+	tail := "2023-09-06 22:52:36,001 ERROR: Service unavailable\n"
+	if err := os.WriteFile(logFile, []byte(prefix+tail), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	for _, input := range []string{"grep ERROR", "grep -c ERROR", "grep -v -i info"} {
+		q, err := grep.CreateGrepQueryFromInput(input)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if !q.SupportsIncrementalMerge() {
+			t.Errorf("Expected %q to support incremental merge", input)
+		}
+
+		prefixOut := q.ExecuteRange(logFile, 0, int64(len(prefix)))
+		tailOut := q.ExecuteRange(logFile, int64(len(prefix)), int64(len(prefix)+len(tail)))
+		merged := q.MergeIncrementalOutputs(prefixOut, tailOut)
+		full := q.Execute(logFile)
+
+		if !grep.GrepOutputsAreEqual(merged, full) {
+			t.Errorf("%q: expected merged output %q (%d lines), but got %q (%d lines)", input, full.Output, full.NumLines, merged.Output, merged.NumLines)
+		}
+	}
+
+	for _, input := range []string{"grep -n ERROR", "grep -A1 ERROR", "grep -ic ERROR -m 1", "grep -2 ERROR", "grep -l ERROR"} {
+		q, _ := grep.CreateGrepQueryFromInput(input)
+		if q.SupportsIncrementalMerge() {
+			t.Errorf("Expected %q to not support incremental merge", input)
+		}
+	}
+}
+
+// Tests that equivalent queries are given the same cache key, and different queries are not
+func TestNormalizedQueries(t *testing.T) {
+	equivalentInputs := [][]string{
+		{"grep -i -c ERROR", "grep -ci ERROR", "grep -c -i ERROR", "grep --count --ignore-case ERROR", "grep -i -c -i ERROR -G"},
+		{"grep -A2 -n GET", "grep -n -A 2 GET", "grep --after-context=2 --line-number GET", "grep -A 1 -nA2 GET"},
+		{"grep -C3 ERROR", "grep -3 ERROR", "grep --context 3 ERROR"},
+		{"grep -e ERROR -e WARN", "grep --regexp=ERROR -e WARN", "grep -h -eERROR -e WARN"},
+		{"grep -H ERROR", "grep -h -H ERROR", "grep --no-filename --with-filename ERROR"}, // last of -H and -h wins
+		{"grep ERROR", "grep -H -h ERROR", "grep -Hh ERROR"},
+	}
+	for _, inputs := range equivalentInputs {
+		expected, _ := grep.CreateGrepQueryFromInput(inputs[0])
+		for _, input := range inputs[1:] {
+			q, err := grep.CreateGrepQueryFromInput(input)
+			if err != nil {
+				t.Fatalf("Error: %v", err)
+			}
+			if q.CacheKey() != expected.CacheKey() {
+				t.Errorf("Expected %q to have cache key %q, but got %q", input, expected.CacheKey(), q.CacheKey())
+			}
+		}
+	}
+
+	differentInputs := []string{"grep -c ERROR", "grep -c error", "grep -e ERROR -e WARN", "grep -e WARN -e ERROR", "grep -E -c ERROR", "grep -c -- -ERROR", "grep -H -c ERROR"}
+	keys := make(map[string]string)
+	for _, input := range differentInputs {
+		q, _ := grep.CreateGrepQueryFromInput(input)
+		if other, ok := keys[q.CacheKey()]; ok {
+			t.Errorf("Expected %q and %q to have different cache keys, but both got %q", input, other, q.CacheKey())
+		}
+		keys[q.CacheKey()] = input
+	}
+}
+
+// Tests that packaged strings are unpackaged into the exact same args, even if they contain the legacy delimiter
+func TestPackagedStringRoundTrip(t *testing.T) {
+	q, err := grep.CreateGrepQueryFromInput(`grep -e "a;b" -e '["x"]' -e ";"`)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	reconstructed := grep.CreateGrepQueryFromPackagedString(q.PackagedString)
+	if !reflect.DeepEqual(reconstructed.CmdArgs, q.CmdArgs) {
+		t.Errorf("Expected args %q, but got %q", q.CmdArgs, reconstructed.CmdArgs)
+	}
+	if reconstructed.PackagedString != q.PackagedString || reconstructed.CacheKey() != q.CacheKey() {
+		t.Errorf("Expected reconstructed query to have the same packaged string and cache key")
+	}
+
+	legacy := grep.CreateGrepQueryFromPackagedString("grep;-c;GET")
+	if !reflect.DeepEqual(legacy.CmdArgs, []string{"grep", "-c", "GET"}) || legacy.PackagedString != `["grep","-c","GET"]` {
+		t.Errorf("Expected legacy packaged string to be migrated, but got args %q and packaged string %s", legacy.CmdArgs, legacy.PackagedString)
+	}
+}
+
+// Tests that queries can't make grep read any file but the log file
+func TestRejectFileAccess(t *testing.T) {
+	for _, input := range []string{
+		`grep "" /etc/shadow`,
+		`grep -e ERROR /etc/shadow`,
+		`grep -f /etc/shadow`,
+		`grep --file=/etc/shadow`,
+		`grep --fil /etc/shadow`,
+		`grep -r ERROR`,
+		`grep -R ERROR`,
+		`grep -d recurse ERROR`,
+		`grep --directories=recurse ERROR`,
+		`grep -D read ERROR`,
+		`grep --include '*.log' ERROR`,
+		`grep --exclude-dir proc ERROR`,
+		`grep -c -- ERROR /etc/shadow`,
+	} {
+		if _, err := grep.CreateGrepQueryFromInput(input); err == nil {
+			t.Errorf("Expected an error for %s", input)
+		}
+	}
+
+	for _, input := range []string{`grep ERROR`, `grep -e ERROR -e WARN`, `grep -c -- -ERROR`, `grep --where level=ERROR`} {
+		if _, err := grep.CreateGrepQueryFromInput(input); err != nil {
+			t.Errorf("Expected %s to be valid, but got %v", input, err)
+		}
+	}
+}
+
+func TestExecuteContextCounts(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	lines := []string{
+		"INFO: Request received from client",
+		"ERROR: Disk full",
+		"INFO: Retrying",
+		"INFO: Request received from client",
+		"INFO: Request received from client",
+		"INFO: Cache cleared",
+		"ERROR: Disk full",
+	}
+	_ = os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+
+	cases := []struct {
+		input                        string
+		expected                     string
+		numLines, matches, contextLn int
+	}{
+		{`grep -A1 ERROR`, lines[1] + "\n" + lines[2] + "\n--\n" + lines[6] + "\n", 4, 2, 1},
+		{`grep -B1 ERROR`, lines[0] + "\n" + lines[1] + "\n--\n" + lines[5] + "\n" + lines[6] + "\n", 5, 2, 2},
+		{`grep -n -C1 Retrying`, "2-" + lines[1] + "\n3:" + lines[2] + "\n4-" + lines[3] + "\n", 3, 1, 2},
+		{`grep -H -A1 Cache`, "vm1.log:" + lines[5] + "\nvm1.log-" + lines[6] + "\n", 2, 1, 1},
+		// context lines that match are matching lines
+		{`grep -C1 Request`, strings.Join(lines[:5], "\n") + "\n" + lines[5] + "\n", 6, 3, 3},
+		{`grep ERROR`, lines[1] + "\n" + lines[6] + "\n", 2, 2, 0},
+	}
+	for _, c := range cases {
+		q, err := grep.CreateGrepQueryFromInput(c.input)
+		if err != nil {
+			t.Fatalf("Error for %s: %v", c.input, err)
+		}
+		gOut := q.ExecuteRange(logFile, 0, int64(len(strings.Join(lines, "\n"))+1))
+		if gOut.Output != c.expected {
+			t.Errorf("%s: expected %q, but got %q", c.input, c.expected, gOut.Output)
+		}
+		if gOut.NumLines != c.numLines || gOut.MatchCount != c.matches || gOut.ContextLines != c.contextLn {
+			t.Errorf("%s: expected %d lines, %d matches and %d context lines, but got %d, %d and %d", c.input,
+				c.numLines, c.matches, c.contextLn, gOut.NumLines, gOut.MatchCount, gOut.ContextLines)
+		}
+	}
+
+	gOut := &grep.GrepOutput{Output: "a\n--\nb\n", Filename: "vm1.log", Machine: "vm1", NumLines: 3, MatchCount: 1, ContextLines: 1}
+	if str := gOut.ToString(); !strings.HasPrefix(str, "------------------------vm1:vm1.log------------------------\n") ||
+		!strings.Contains(str, "Num Matches: 1\nNum Context Lines: 1\n") {
+		t.Errorf("Expected a machine/file header and the counts, but got:\n%s", str)
+	}
+}
+
+func TestExecuteMatchCounts(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	content := "ERROR: Disk full ERROR again\nINFO: Retrying\nERROR: Disk full\n"
+	_ = os.WriteFile(logFile, []byte(content), 0644)
+
+	cases := []struct {
+		input             string
+		expected          string
+		numLines, matches int
+	}{
+		{`grep -c ERROR`, "2\n", 1, 2},
+		{`grep -c -H ERROR`, "vm1.log:2\n", 1, 2},
+		{`grep -co ERROR`, "2\n", 1, 2}, // -c counts the lines, not the matches of -o
+		{`grep -c -A1 ERROR`, "2\n", 1, 2},
+		{`grep -o ERROR`, "ERROR\nERROR\nERROR\n", 3, 2},
+		{`grep -on ERROR`, "1:ERROR\n1:ERROR\n3:ERROR\n", 3, 2},
+		{`grep -l ERROR`, "vm1.log\n", 1, 2},
+		{`grep -L ERROR`, "", 0, 2},
+		{`grep -L CRITICAL`, "vm1.log\n", 1, 0},
+		{`grep -q ERROR`, "", 0, 2},
+		{`grep --files-with-matches -i retrying`, "vm1.log\n", 1, 1},
+		{`grep -c --where level=ERROR`, "2\n", 1, 2},
+	}
+	for _, c := range cases {
+		q, err := grep.CreateGrepQueryFromInput(c.input)
+		if err != nil {
+			t.Fatalf("Error for %s: %v", c.input, err)
+		}
+		gOut := q.ExecuteRange(logFile, 0, int64(len(content)))
+		if gOut.Output != c.expected || gOut.NumLines != c.numLines || gOut.MatchCount != c.matches {
+			t.Errorf("%s: expected %q with %d lines and %d matches, but got %q with %d lines and %d matches",
+				c.input, c.expected, c.numLines, c.matches, gOut.Output, gOut.NumLines, gOut.MatchCount)
+		}
+	}
+
+	// counts of -c are added up when merging the output of the tail of a file
+	q, _ := grep.CreateGrepQueryFromInput(`grep -c ERROR`)
+	merged := q.MergeIncrementalOutputs(q.ExecuteRange(logFile, 0, 29), q.ExecuteRange(logFile, 29, int64(len(content))))
+	if merged.Output != "2\n" || merged.MatchCount != 2 {
+		t.Errorf("Expected merged count of 2, but got %q with %d matches", merged.Output, merged.MatchCount)
+	}
+}
+
+func TestExecuteErrorsAndNoMatches(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	_ = os.WriteFile(logFile, []byte("INFO: Retrying\n"), 0644)
+
+	q, _ := grep.CreateGrepQueryFromInput(`grep ERROR`)
+	if gOut := q.Execute(logFile); gOut.ExitStatus != grep.EXIT_NO_MATCH || gOut.Failed() || gOut.Error != "" {
+		t.Errorf("Expected no matches w/o an error, but got exit status %d and error %q", gOut.ExitStatus, gOut.Error)
+	}
+	if gOut := q.Execute(filepath.Join(t.TempDir(), "missing.log")); !gOut.Failed() || !strings.Contains(gOut.Error, "missing.log") {
+		t.Errorf("Expected an error for a missing log file, but got exit status %d and error %q", gOut.ExitStatus, gOut.Error)
+	}
+
+	q, _ = grep.CreateGrepQueryFromInput(`grep -c ERROR`)
+	if gOut := q.Execute(logFile); gOut.ExitStatus != grep.EXIT_NO_MATCH || gOut.Output != "0\n" {
+		t.Errorf("Expected a count of 0 w/o an error, but got %q with exit status %d", gOut.Output, gOut.ExitStatus)
+	}
+
+	q, _ = grep.CreateGrepQueryFromInput(`grep -E 'Retry(ing'`)
+	gOut := q.Execute(logFile)
+	if !gOut.Failed() || gOut.Error == "" || gOut.Output != "" {
+		t.Errorf("Expected an error for an invalid regex, but got %q with exit status %d", gOut.Output, gOut.ExitStatus)
+	}
+	if !strings.Contains(gOut.ToString(), "Error: "+gOut.Error+"\n") {
+		t.Errorf("Expected the error in the formatted output, but got:\n%s", gOut.ToString())
+	}
+
+	q, _ = grep.CreateGrepQueryFromInput(`grep Retrying`)
+	if gOut := q.Execute(logFile); gOut.ExitStatus != grep.EXIT_MATCH || gOut.Output != "INFO: Retrying\n" || gOut.Stderr != "" {
+		t.Errorf("Expected a match, but got %q with exit status %d", gOut.Output, gOut.ExitStatus)
+	}
+
+	// warnings of a query that didn't fail are kept, ex: why grep printed no lines of a file with binary data
+	_ = os.WriteFile(logFile, []byte("ERROR: Disk full\nbinary\x00data ERROR\n"), 0644)
+	q, _ = grep.CreateGrepQueryFromInput(`grep ERROR`)
+	gOut = q.Execute(logFile)
+	if gOut.Failed() || !strings.Contains(gOut.Stderr, "binary file matches") {
+		t.Errorf("Expected the binary file warning in Stderr, but got exit status %d with stderr %q", gOut.ExitStatus, gOut.Stderr)
+	}
+	if !strings.Contains(gOut.ToString(), "Stderr: "+gOut.Stderr+"\n") {
+		t.Errorf("Expected the warning in the formatted output, but got:\n%s", gOut.ToString())
+	}
+}
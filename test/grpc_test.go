@@ -0,0 +1,69 @@
+package test
+
+import (
+	"context"
+	"cs425_mp1/internal/cache"
+	"cs425_mp1/internal/distributed_engine"
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGrpcService(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	writeBenchLog(t, logFile, 6) // output of "grep -v INFO" is larger than the max size of a gRPC message, so must be streamed
+	engine := distributed_engine.CreateEngine(logFile, "127.0.0.1:18047", nil, cache.Config{MaxEntries: 10}, false, "")
+	engine.InitializeGrpcServer("127.0.0.1:19047")
+
+	client, err := rpc.NewClient("127.0.0.1:19047")
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, input := range []string{`grep -v INFO`, `grep -c ERROR`, `grep --count-by level`, `grep "no such line"`,
+		`grep --where 'level=ERROR OR level=WARN'`} {
+		q, _ := grep.CreateGrepQueryFromInput(input)
+		expected := engine.ExecuteLocal(q)
+		actual, err := client.Query(ctx, q.CmdArgs)
+		if err != nil {
+			t.Fatalf("%s: query failed: %v", input, err)
+		}
+		if expected.Output != actual.Output || expected.MatchCount != actual.MatchCount || len(expected.Aggregates) != len(actual.Aggregates) ||
+			len(expected.Records) != len(actual.Records) {
+			t.Errorf("%s: expected %d matches over gRPC like locally, but got %d", input, expected.MatchCount, actual.MatchCount)
+		}
+	}
+
+	if _, err = client.Query(ctx, []string{"rm", "-rf", "/"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected the invalid query to be rejected, but got %v", err)
+	}
+
+	if health, err := client.Health(ctx); err != nil || health.Status != rpc.STATUS_SERVING || health.LogFileBytes == 0 {
+		t.Errorf("Expected the machine to be serving, but got %+v (%v)", health, err)
+	}
+	if stats, err := client.Stats(ctx); err != nil || stats.Cache.Entries == 0 || stats.DiskCache != nil {
+		t.Errorf("Expected the stats of the cache the queries were stored in, but got %+v (%v)", stats, err)
+	}
+
+	// a machine that is down fails the call once the deadline passes
+	down, _ := rpc.NewClient("127.0.0.1:19048")
+	defer down.Close()
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer shortCancel()
+	if _, err = down.Query(shortCtx, []string{"grep", "ERROR"}); err == nil {
+		t.Errorf("Expected the query to a machine that is down to fail")
+	}
+	_ = os.Remove(logFile)
+	if health, _ := client.Health(ctx); health.Status != rpc.STATUS_NOT_SERVING {
+		t.Errorf("Expected the machine w/o its log file not to be serving, but got %+v", health)
+	}
+}
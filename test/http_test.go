@@ -0,0 +1,106 @@
+package test
+
+import (
+	"bytes"
+	"cs425_mp1/internal/cache"
+	"cs425_mp1/internal/distributed_engine"
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/rpc"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHttpApi(t *testing.T) {
+	dir := t.TempDir()
+	localLog, peerLog := filepath.Join(dir, "vm1.log"), filepath.Join(dir, "vm2.log")
+	_ = os.WriteFile(localLog, []byte("ERROR: Disk full\nINFO: Retrying\nERROR: Disk still full\n"), 0644)
+	_ = os.WriteFile(peerLog, []byte("ERROR: Cache miss\nINFO: Cache cleared\n"), 0644)
+
+	peer := distributed_engine.CreateEngine(peerLog, "127.0.0.1:18048", nil, cache.Config{MaxEntries: 10}, false, "")
+	peer.InitializeServer()
+	engine := distributed_engine.CreateEngine(localLog, "127.0.0.1:18049", []string{"127.0.0.1:18048"}, cache.Config{MaxEntries: 10}, false, "")
+	engine.ConnectToPeers()
+	server := httptest.NewServer(engine.HttpHandler())
+	defer server.Close()
+
+	post := func(body string) (int, *distributed_engine.DistributedOutput) {
+		resp, err := http.Post(server.URL+"/query", "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("POST /query failed: %v", err)
+		}
+		defer resp.Body.Close()
+		result := new(distributed_engine.DistributedOutput)
+		_ = json.NewDecoder(resp.Body).Decode(result)
+		return resp.StatusCode, result
+	}
+
+	// queries sent at the same time take turns on the connection to the peer
+	var wg sync.WaitGroup
+	for _, body := range []string{`{"CmdArgs": ["grep", "ERROR"]}`, `{"Query": "grep 'ERROR'"}`, `{"Query": "grep -c ERROR"}`} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			if status, result := post(body); status != http.StatusOK || len(result.Outputs) != 2 || result.TotalLines != 3 {
+				t.Errorf("%s: expected 3 lines from 2 machines, but got %d from %d (status %d)", body, result.TotalLines, len(result.Outputs), status)
+			}
+		}(body)
+	}
+	wg.Wait()
+	if status, result := post(`{"Query": "grep --count-by level"}`); status != http.StatusOK || len(result.Aggregates) != 2 {
+		t.Errorf("Expected the counts of 2 levels, but got %+v (status %d)", result.Aggregates, status)
+	}
+	if status, result := post(`{"Query": "grep . --max-results 2"}`); status != http.StatusOK || result.NextPage == nil {
+		t.Errorf("Expected a next page, but got %v (status %d)", result.NextPage, status)
+	}
+	for _, body := range []string{`{"Query": "rm -rf /"}`, `{"CmdArgs": ["grep"]}`, `not json`,
+		`{"CmdArgs": ["grep", "", "/etc/shadow"]}`, `{"CmdArgs": ["grep", "-r", "ERROR"]}`} {
+		if status, _ := post(body); status != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, but got status %d", body, status)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/local/query?q=" + url.QueryEscape(`grep -c "Disk"`))
+	if err != nil {
+		t.Fatalf("GET /local/query failed: %v", err)
+	}
+	gOut := new(grep.GrepOutput)
+	_ = json.NewDecoder(resp.Body).Decode(gOut)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || gOut.Output != "2\n" {
+		t.Errorf("Expected a count of 2 on this machine only, but got %q (status %d)", gOut.Output, resp.StatusCode)
+	}
+	if resp, _ = http.Get(server.URL + "/local/query"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a query w/o q to be rejected, but got status %d", resp.StatusCode)
+	}
+	if resp, _ = http.Get(server.URL + "/query"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /query to be rejected, but got status %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(server.URL + "/health")
+	health := new(rpc.HealthReply)
+	_ = json.NewDecoder(resp.Body).Decode(health)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || health.Status != rpc.STATUS_SERVING {
+		t.Errorf("Expected the machine to be serving, but got %+v (status %d)", health, resp.StatusCode)
+	}
+	_ = os.Remove(localLog)
+	if resp, _ = http.Get(server.URL + "/health"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the machine w/o its log file not to be serving, but got status %d", resp.StatusCode)
+	}
+
+	// the web UI is served from the binary and only uses the API of the machine serving it
+	resp, _ = http.Get(server.URL + "/")
+	page, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), `fetch("/query"`) || strings.Contains(string(page), "src=") {
+		t.Errorf("Expected the self-contained web UI, but got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,170 @@
+package test
+
+import (
+	"bufio"
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/index"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Size of the log file generated for the benchmarks if BENCH_LOG_FILE isn't set. Can be changed with BENCH_LOG_MB
+const DEFAULT_BENCH_LOG_MB = 64
+
+var benchMessages = map[string][]string{
+	"INFO":     {"Application started", "Cache cleared", "Database connection established", "Server started on port 8080"},
+	"DEBUG":    {"Entering function: calculate_total", "Variable 'x' has value 42", "Debugging trace for network layer"},
+	"ERROR":    {"Database query timeout", "Service unavailable", "Socket connection error: Connection refused"},
+	"WARNING":  {"Low disk space warning", "Network connection unstable", "Deprecated function in use"},
+	"CRITICAL": {"System crash: unrecoverable error", "Security breach detected"},
+}
+
+var benchLevels = []string{"INFO", "DEBUG", "ERROR", "WARNING", "CRITICAL"}
+
+// Writes a log file of about sizeMB in the format of scripts/generate_log_files.py, with a rare line
+// "Disk failure on node <n>" every ~10MB so that queries for it can skip most of the file
+func writeBenchLog(b testing.TB, filename string, sizeMB int) {
+	file, err := os.Create(filename)
+	if err != nil {
+		b.Fatalf("Failed to create %s: %v", filename, err)
+	}
+	writer := bufio.NewWriter(file)
+	random := rand.New(rand.NewSource(425))
+	ts := time.Date(2023, 9, 6, 0, 0, 0, 0, time.UTC)
+	written, nextRare := 0, 0
+	for written < sizeMB<<20 {
+		level := benchLevels[random.Intn(len(benchLevels))]
+		msg := benchMessages[level][random.Intn(len(benchMessages[level]))]
+		if written >= nextRare {
+			level, msg = "CRITICAL", "Disk failure on node "+strconv.Itoa(nextRare>>20)
+			nextRare += 10 << 20
+		}
+		n, _ := fmt.Fprintf(writer, "%s %s: %s\n", ts.Format("2006-01-02 15:04:05,000"), level, msg)
+		written += n
+		ts = ts.Add(time.Millisecond)
+	}
+	_ = writer.Flush()
+	_ = file.Close()
+}
+
+func TestExecuteWithIndex(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	writeBenchLog(t, logFile, 2)
+	executor := grep.NewExecutor(nil)
+	indexed := grep.NewExecutor(nil)
+	idx := index.New(logFile, 4096)
+	indexed.SetIndex(idx)
+
+	inputs := []string{
+		`grep "Disk failure"`,
+		`grep -c -i "disk FAILURE"`,
+		`grep -e "Disk failure" -e "Security breach"`,
+		`grep -F -w "node 1"`,
+		`grep -E "Disk failure|Security breach"`, // not a literal, so the whole file is grepped
+		`grep "^2023-09-06 00:00:00,00"`,
+		`grep -v INFO`,
+		`grep "Disk failure" --since "2023-09-06 00:00:01"`,
+		`grep ab`, // too short to look up
+		`grep "no such line"`,
+	}
+	check := func() {
+		for _, input := range inputs {
+			q, err := grep.CreateGrepQueryFromInput(input)
+			if err != nil {
+				t.Fatalf("Error for %s: %v", input, err)
+			}
+			expected, actual := executor.Execute(q, logFile), indexed.Execute(q, logFile)
+			if expected.Output != actual.Output || expected.MatchCount != actual.MatchCount || expected.ExitStatus != actual.ExitStatus {
+				t.Errorf("%s: expected %d matches with the index like without it, but got %d", input, expected.MatchCount, actual.MatchCount)
+			}
+		}
+	}
+	check()
+	if stats := idx.Stats(); stats.Blocks == 0 || stats.IndexedBytes == 0 {
+		t.Errorf("Expected the file to be indexed, but got %+v", stats)
+	}
+
+	// lines appended after the index was built are found too
+	file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = file.WriteString("2023-09-07 00:00:00,000 CRITICAL: Disk failure on node 99\n")
+	_ = file.Close()
+	check()
+
+	// a rotated file is indexed again from scratch
+	_ = os.Remove(logFile)
+	_ = os.WriteFile(logFile, []byte("2023-09-08 00:00:00,000 INFO: Cache cleared\n"), 0644)
+	check()
+	if stats := idx.Stats(); stats.IndexedBytes != 44 {
+		t.Errorf("Expected the rotated file to be indexed again, but got %+v", stats)
+	}
+}
+
+// Returns the log file to benchmark queries on: BENCH_LOG_FILE (ex: generated with
+// "python scripts/generate_log_files.py big.log 5000000"), or a generated file of BENCH_LOG_MB
+func benchLogFile(b *testing.B) string {
+	if logFile := os.Getenv("BENCH_LOG_FILE"); logFile != "" {
+		return logFile
+	}
+	sizeMB := DEFAULT_BENCH_LOG_MB
+	if env, err := strconv.Atoi(os.Getenv("BENCH_LOG_MB")); err == nil && env > 0 {
+		sizeMB = env
+	}
+	logFile := filepath.Join(b.TempDir(), "bench.log")
+	writeBenchLog(b, logFile, sizeMB)
+	return logFile
+}
+
+func benchmarkQuery(b *testing.B, input string, withIndex bool) {
+	logFile := benchLogFile(b)
+	executor := grep.NewExecutor(nil)
+	if withIndex {
+		idx := index.New(logFile, index.DEFAULT_BLOCK_SIZE)
+		if err := idx.Update(); err != nil {
+			b.Fatalf("Failed to index %s: %v", logFile, err)
+		}
+		executor.SetIndex(idx)
+	}
+	q, err := grep.CreateGrepQueryFromInput(input)
+	if err != nil {
+		b.Fatalf("Error for %s: %v", input, err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if gOut := executor.Execute(q, logFile); gOut.Failed() {
+			b.Fatalf("Query failed: %s", gOut.Error)
+		}
+	}
+}
+
+func BenchmarkRareQueryWithIndex(b *testing.B) {
+	benchmarkQuery(b, `grep -c "Disk failure"`, true)
+}
+
+func BenchmarkRareQueryWithoutIndex(b *testing.B) {
+	benchmarkQuery(b, `grep -c "Disk failure"`, false)
+}
+
+func BenchmarkCommonQueryWithIndex(b *testing.B) {
+	benchmarkQuery(b, `grep -c "Database query timeout"`, true)
+}
+
+func BenchmarkCommonQueryWithoutIndex(b *testing.B) {
+	benchmarkQuery(b, `grep -c "Database query timeout"`, false)
+}
+
+// Time taken to index the log file from scratch
+func BenchmarkBuildIndex(b *testing.B) {
+	logFile := benchLogFile(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := index.New(logFile, index.DEFAULT_BLOCK_SIZE).Update(); err != nil {
+			b.Fatalf("Failed to index %s: %v", logFile, err)
+		}
+	}
+}
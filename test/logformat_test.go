@@ -0,0 +1,194 @@
+package test
+
+import (
+	"cs425_mp1/internal/grep"
+	"cs425_mp1/internal/logformat"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLogFormats(t *testing.T) {
+	cases := []struct {
+		format   string
+		line     string
+		expected map[string]string
+	}{
+		{"default", "2023-09-06 22:52:35,317 INFO: Cache size: 256 MB",
+			map[string]string{"time": "2023-09-06 22:52:35,317", "level": "INFO", "msg": "Cache size: 256 MB"}},
+		{"default", "ERROR: Permission denied: User 'guest' cannot access 'admin' area 0",
+			map[string]string{"level": "ERROR", "msg": "Permission denied: User 'guest' cannot access 'admin' area 0"}},
+		{"json", `{"level": "ERROR", "msg": "Database query timeout", "code": 504}`,
+			map[string]string{"level": "ERROR", "msg": "Database query timeout", "code": "504"}},
+		{"logfmt", `level=error msg="Database query \"timeout\"" retry`,
+			map[string]string{"level": "error", "msg": `Database query "timeout"`, "retry": ""}},
+		{`regex:^(?P<host>\S+) (?P<status>\d{3})$`, "fa23-cs425-1901 404",
+			map[string]string{"host": "fa23-cs425-1901", "status": "404"}},
+	}
+
+	for _, c := range cases {
+		parser, err := logformat.NewParser(c.format)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.format, err)
+		}
+		fields, ok := parser.Parse(c.line)
+		if !ok || !reflect.DeepEqual(fields, c.expected) {
+			t.Errorf("%s: expected %v, but got %v (ok = %v)", c.format, c.expected, fields, ok)
+		}
+	}
+
+	for _, format := range []string{"xml", "regex:(", "regex:^no named groups$"} {
+		if _, err := logformat.NewParser(format); err == nil {
+			t.Errorf("Expected an error for log format %q", format)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	record := &grep.LogRecord{Fields: map[string]string{"level": "ERROR", "msg": "Database query timeout"}}
+	cases := map[string]bool{
+		`level=ERROR`:                                true,
+		`level=ERROR AND msg~"timeout"`:              true,
+		`level=ERROR AND msg~"^timeout"`:             false,
+		`level=INFO OR msg~time`:                     true,
+		`NOT level=ERROR`:                            false,
+		`level!=INFO and not (msg!~query OR x=1)`:    true,
+		`level=WARNING OR level=ERROR AND msg~query`: true,
+		`(level=WARNING OR level=ERROR) AND x=1`:     false,
+		`missing!=ERROR AND missing!~x`:              true,
+		`msg="Database query timeout"`:               true,
+	}
+	for expr, expected := range cases {
+		filter, err := grep.ParseFilter(expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", expr, err)
+		} else if filter.Matches(record) != expected {
+			t.Errorf("%s: expected %v", expr, expected)
+		}
+	}
+
+	invalid := []string{``, `level`, `level=`, `level=ERROR AND`, `(level=ERROR`, `level=ERROR)`, `msg~"(`, `msg="unterminated`}
+	for _, expr := range invalid {
+		if _, err := grep.ParseFilter(expr); err == nil {
+			t.Errorf("Expected an error for filter %q", expr)
+		}
+	}
+}
+
+func TestExecuteWhere(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "structured.log")
+	lines := []string{
+		"2023-09-06 22:52:35,317 ERROR: Database query timeout",
+		"2023-09-06 22:52:35,318 INFO: Database query timeout was retried",
+		"2023-09-06 22:52:35,319 ERROR: Service unavailable",
+		"not a log line with ERROR and timeout",
+	}
+	_ = os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+
+	q, err := grep.CreateGrepQueryFromInput(`grep --where 'level=ERROR AND msg~"timeout"'`)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	gOut := q.Execute(logFile)
+	if gOut.Output != lines[0]+"\n" || gOut.NumLines != 1 {
+		t.Errorf("Expected only the first line, but got %q (%d lines)", gOut.Output, gOut.NumLines)
+	}
+	if len(gOut.Records) != 1 || gOut.Records[0].Fields["time"] != "2023-09-06 22:52:35,317" {
+		t.Errorf("Expected the first line's parsed fields in the records, but got %v", gOut.Records)
+	}
+
+	q, _ = grep.CreateGrepQueryFromInput(`grep -ic database --where=level=INFO`)
+	if gOut = q.Execute(logFile); gOut.Output != "1\n" {
+		t.Errorf("Expected count of 1, but got %q", gOut.Output)
+	}
+
+	if _, err = grep.CreateGrepQueryFromInput(`grep -n ERROR --where level=ERROR`); err == nil {
+		t.Errorf("Expected an error combining -n with --where")
+	}
+	if _, err = grep.CreateGrepQueryFromInput(`grep ERROR --where 'level=ERROR AND'`); err == nil {
+		t.Errorf("Expected an error for an invalid filter")
+	}
+}
+
+func TestExecuteTimeRange(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "timed.log")
+	lines := []string{
+		"2023-09-05 23:59:59,999 INFO: Day before",
+		"2023-09-06 22:50:00,000 ERROR: Disk full",
+		"    at the continuation of the disk full error",
+		"2023-09-06 22:51:30,000 INFO: Retrying",
+		"2023-09-06 22:52:00,000 ERROR: Disk still full",
+		"2023-09-06 22:53:00,000 INFO: Recovered",
+	}
+	_ = os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{`grep --since "2023-09-06 22:50"`, lines[1:]},
+		{`grep --since "2023-09-06 22:50:00,001" --until "2023-09-06 22:52"`, lines[3:5]},
+		{`grep --until 2023-09-05`, lines[:1]},
+		{`grep --since 2023-09-06 --until 2023-09-06`, lines[1:]}, // --until includes the whole day
+		{`grep --until "2023-09-06 22:51"`, lines[:4]},            // and the whole minute
+		{`grep ERROR --since 22:51`, lines[4:5]},
+		{`grep --since 22:50 --until 22:51`, lines[1:4]},
+		{`grep --since 22:52 --until 22:52`, lines[4:5]},
+		{`grep --since 2023-09-07`, nil},
+		{`grep -i "disk full" --since "2023-09-06 22:50:00" --until "2023-09-06 22:50:00"`, lines[1:3]}, // continuation lines belong to the line above
+	}
+	for _, c := range cases {
+		q, err := grep.CreateGrepQueryFromInput(c.input)
+		if err != nil {
+			t.Fatalf("Error for %s: %v", c.input, err)
+		}
+		expected := ""
+		if len(c.expected) > 0 {
+			expected = strings.Join(c.expected, "\n") + "\n"
+		}
+		if gOut := q.Execute(logFile); gOut.Output != expected {
+			t.Errorf("%s: expected %q, but got %q", c.input, expected, gOut.Output)
+		}
+	}
+
+	// times of day are the last time they came before the end of the log, which may be the day before
+	overnightFile := filepath.Join(t.TempDir(), "overnight.log")
+	overnight := []string{
+		"2023-09-06 22:50:00,000 INFO: Backup started",
+		"2023-09-06 23:00:00,317 ERROR: Disk full",
+		"2023-09-07 00:05:00,000 INFO: Backup finished",
+		"2023-09-07 00:10:00,000 INFO: Idle",
+	}
+	_ = os.WriteFile(overnightFile, []byte(strings.Join(overnight, "\n")+"\n"), 0644)
+	for _, c := range []struct {
+		input    string
+		expected []string
+	}{
+		{`grep --since 22:50 --until 23:00`, overnight[:2]},
+		{`grep --since 22:55`, overnight[1:]},
+		{`grep --since 23:50 --until 00:05`, overnight[2:3]},
+	} {
+		q, _ := grep.CreateGrepQueryFromInput(c.input)
+		expected := strings.Join(c.expected, "\n") + "\n"
+		if gOut := q.Execute(overnightFile); gOut.Output != expected {
+			t.Errorf("%s: expected %q, but got %q", c.input, expected, gOut.Output)
+		}
+	}
+
+	q, _ := grep.CreateGrepQueryFromInput(`grep -c ERROR --since "2023-09-06 22:51"`)
+	if gOut := q.Execute(logFile); gOut.Output != "1\n" {
+		t.Errorf("Expected count of 1, but got %q", gOut.Output)
+	}
+
+	for _, input := range []string{
+		`grep ERROR --since yesterday`,
+		`grep ERROR --since 2023-09-07 --until 2023-09-06`,
+		`grep -n ERROR --since 22:50`,
+	} {
+		if _, err := grep.CreateGrepQueryFromInput(input); err == nil {
+			t.Errorf("Expected an error for %s", input)
+		}
+	}
+}
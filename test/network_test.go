@@ -0,0 +1,84 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"cs425_mp1/internal/network"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCompressedFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	large := []byte(strings.Repeat("2023-09-06 22:52:35,317 ERROR: Database query timeout\n", 1000))
+	small := []byte("ERROR: Disk full\n")
+	type sent struct {
+		data            []byte
+		compress        bool
+		shouldBeCompact bool
+	}
+	frames := []sent{{large, true, true}, {small, true, false}, {large, false, false}}
+
+	var recorder network.StatsRecorder
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for _, frame := range frames {
+			if frame.compress {
+				info, _ := network.SendCompressed(frame.data, client, network.DEFAULT_COMPRESSION_THRESHOLD)
+				recorder.RecordSent(info)
+			} else { // peers running an older version send plain frames
+				_ = network.SendRequest(frame.data, client)
+			}
+		}
+	}()
+
+	reader := bufio.NewReader(server)
+	for i, frame := range frames {
+		data, info, err := network.ReadFrame(reader)
+		if err != nil || !bytes.Equal(data, frame.data) {
+			t.Fatalf("Frame %d: expected the data sent back, but got %d bytes (err = %v)", i, len(data), err)
+		}
+		if info.Compressed != frame.shouldBeCompact || (info.Compressed && info.WireSize >= info.Size/10) {
+			t.Errorf("Frame %d: expected compressed = %v, but got %+v", i, frame.shouldBeCompact, info)
+		}
+	}
+
+	<-done
+	stats := recorder.Stats()
+	if stats.FramesSent != 2 || stats.FramesSentCompressed != 1 || stats.BytesSent != int64(len(large)+len(small)) ||
+		stats.WireBytesSent >= stats.BytesSent/2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestFrameSizeLimits(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// a plain frame that large would be read as a compressed one, so it isn't sent at all. The slice is never
+	// written to, so it doesn't take 2GB of memory
+	if err := network.SendRequest(make([]byte, network.COMPRESSED_FLAG), client); err != network.ErrFrameTooLarge {
+		t.Errorf("Expected ErrFrameTooLarge for a frame of 2^31 bytes, but got %v", err)
+	}
+
+	large := []byte(strings.Repeat("0", 100000))
+	for _, compress := range []bool{true, false} {
+		go func(compress bool) {
+			if compress {
+				_, _ = network.SendCompressed(large, client, network.DEFAULT_COMPRESSION_THRESHOLD)
+			} else {
+				_ = network.SendRequest(large, client)
+			}
+		}(compress)
+		// the compressed frame is only a few hundred bytes, but still decompresses past the limit
+		if _, _, err := network.ReadFrameLimit(bufio.NewReader(server), len(large)-1); err != network.ErrFrameTooLarge {
+			t.Errorf("Compressed = %v: expected ErrFrameTooLarge, but got %v", compress, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"cs425_mp1/internal/cache"
+	"cs425_mp1/internal/distributed_engine"
+	"cs425_mp1/internal/grep"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecuteLocalPages(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	lines := []string{"ERROR: a", "INFO: b", "ERROR: c", "ERROR: d", "ERROR: e", "ERROR: f", "ERROR: g"}
+	_ = os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	engine := distributed_engine.CreateEngine(logFile, ":0", nil, cache.Config{MaxEntries: 10}, false, "")
+	hostname, _ := os.Hostname()
+
+	q, _ := grep.CreateGrepQueryFromInput(`grep ERROR --max-results 4`)
+	expectedPages := [][]string{{lines[0], lines[2], lines[3], lines[4]}, {lines[5], lines[6]}}
+	for i, expectedLines := range expectedPages {
+		gOut := engine.ExecuteLocal(q)
+		expected := strings.Join(expectedLines, "\n") + "\n"
+		if gOut.Output != expected || gOut.NumLines != len(expectedLines) || gOut.MatchCount != 6 {
+			t.Errorf("Page %d: expected %q with 6 matches in total, but got %q with %d", i+1, expected, gOut.Output, gOut.MatchCount)
+		}
+		if gOut.CacheHit != (i > 0) { // later pages are served from the output cached for the first one
+			t.Errorf("Page %d: expected cache hit = %v", i+1, i > 0)
+		}
+
+		outputs := []grep.GrepOutput{*gOut}
+		page, _, _ := q.Page()
+		cursor := page.LimitResults(outputs)
+		if isLast := i == len(expectedPages)-1; isLast != (cursor == "") || gOut.Truncated == isLast {
+			t.Errorf("Page %d: expected truncated = %v, but got cursor %q", i+1, !isLast, cursor)
+		}
+		q = q.WithCursor(cursor)
+	}
+
+	// the querying machine keeps --max-results lines in total, in machine order
+	q, _ = grep.CreateGrepQueryFromInput(`grep ERROR --max-results 3`)
+	page, _, _ := q.Page()
+	outputs := []grep.GrepOutput{*engine.ExecuteLocal(q), *engine.ExecuteLocal(q)}
+	outputs[1].Machine = "peer"
+	cursor := page.LimitResults(outputs)
+	if outputs[0].NumLines != 3 || outputs[1].NumLines != 0 || cursor != hostname+":3,peer:0" {
+		t.Errorf("Expected 3 lines from the first machine and none from the second, but got %d and %d, cursor %q",
+			outputs[0].NumLines, outputs[1].NumLines, cursor)
+	}
+
+	// with a smaller limit per machine, the page has lines of every machine
+	q, _ = grep.CreateGrepQueryFromInput(`grep ERROR --max-results 3 --max-results-per-machine 2`)
+	page, _, _ = q.Page()
+	outputs = []grep.GrepOutput{*engine.ExecuteLocal(q), *engine.ExecuteLocal(q)}
+	outputs[1].Machine = "peer"
+	cursor = page.LimitResults(outputs)
+	if outputs[0].NumLines != 2 || outputs[1].NumLines != 1 || cursor != hostname+":2,peer:1" {
+		t.Errorf("Expected 2 lines from the first machine and 1 from the second, but got %d and %d, cursor %q",
+			outputs[0].NumLines, outputs[1].NumLines, cursor)
+	}
+
+	// a machine missing from the cursor already returned all of its lines
+	if gOut := engine.ExecuteLocal(q.WithCursor("peer:1")); gOut.Output != "" || gOut.Truncated {
+		t.Errorf("Expected no lines for a machine not in the cursor, but got %q", gOut.Output)
+	}
+
+	for _, input := range []string{
+		`grep ERROR --max-results 0`,
+		`grep -c ERROR --max-results 5`,
+		`grep ERROR --cursor x:1`,
+		`grep ERROR --max-results 5 --cursor x`,
+		`grep --count-by level --max-results 5`,
+		`grep ERROR --max-results-per-machine 2`,
+		`grep ERROR --max-results 5 --max-results-per-machine 6`,
+	} {
+		if _, err := grep.CreateGrepQueryFromInput(input); err == nil {
+			t.Errorf("Expected an error for %s", input)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"cs425_mp1/internal/grep"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecutePatternExpr(t *testing.T) {
+	dir := t.TempDir()
+	logFile1 := filepath.Join(dir, "vm1.log")
+	logFile2 := filepath.Join(dir, "vm2.log")
+	lines1 := []string{
+		"2023-09-06 22:52:35,317 ERROR: Database query timeout",
+		"2023-09-06 22:52:35,318 ERROR: Connection reset",
+		"2023-09-06 22:52:35,319 INFO: Connection reset was retried",
+		"2023-09-06 22:52:35,320 INFO: Cache cleared",
+	}
+	lines2 := []string{
+		"2023-09-06 22:52:36,000 ERROR: Connection reset",
+		"2023-09-06 22:52:36,001 error: disk full",
+	}
+	_ = os.WriteFile(logFile1, []byte(strings.Join(lines1, "\n")+"\n"), 0644)
+	_ = os.WriteFile(logFile2, []byte(strings.Join(lines2, "\n")+"\n"), 0644)
+
+	cases := []struct {
+		input              string
+		expected1, expect2 string
+	}{
+		{`grep --expr 'ERROR AND NOT timeout'`, lines1[1] + "\n", lines2[0] + "\n"},
+		{`grep --expr '(timeout OR "was retried") AND NOT Cache'`, lines1[0] + "\n" + lines1[2] + "\n", ""},
+		{`grep --expr 'Cache OR INFO AND reset OR full'`, lines1[2] + "\n" + lines1[3] + "\n", lines2[1] + "\n"}, // AND first
+		// the file pattern matches a line after the lines it selects
+		{`grep --expr 'ERROR AND file:"Cache cleared"'`, lines1[0] + "\n" + lines1[1] + "\n", ""},
+		{`grep --expr 'ERROR AND NOT file:"Cache cleared"'`, "", lines2[0] + "\n"},
+		{`grep -i --expr 'error AND NOT reset'`, lines1[0] + "\n", lines2[1] + "\n"},
+		{`grep -c --expr 'Connection AND reset'`, "2\n", "1\n"},
+		{`grep -F --expr '"query timeout" OR .'`, lines1[0] + "\n", ""},
+		{`grep --expr 'level=ERROR' --where level=INFO`, "", ""},
+		{`grep --expr 'reset' --where level=INFO`, lines1[2] + "\n", ""},
+	}
+	for _, c := range cases {
+		q, err := grep.CreateGrepQueryFromInput(c.input)
+		if err != nil {
+			t.Fatalf("Error for %s: %v", c.input, err)
+		}
+		if gOut := q.Execute(logFile1); gOut.Output != c.expected1 {
+			t.Errorf("%s: expected %q on vm1, but got %q", c.input, c.expected1, gOut.Output)
+		}
+		if gOut := q.Execute(logFile2); gOut.Output != c.expect2 {
+			t.Errorf("%s: expected %q on vm2, but got %q", c.input, c.expect2, gOut.Output)
+		}
+	}
+
+	q, _ := grep.CreateGrepQueryFromInput(`grep --expr 'ERROR AND file:cleared'`)
+	if q.SupportsIncrementalMerge() {
+		t.Errorf("Expected expressions with file patterns to not support incremental merges")
+	}
+
+	for _, input := range []string{
+		`grep ERROR --expr 'reset'`,
+		`grep -n --expr 'reset'`,
+		`grep --expr 'ERROR AND'`,
+		`grep --expr 'ERROR AND "unterminated'`,
+		`grep --expr '"a[" OR b'`,
+	} {
+		if _, err := grep.CreateGrepQueryFromInput(input); err == nil {
+			t.Errorf("Expected an error for %s", input)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"cs425_mp1/internal/cache"
+	"cs425_mp1/internal/distributed_engine"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamMatches(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "vm1.log")
+	_ = os.WriteFile(logFile, []byte("ERROR: Disk full\nINFO: Retrying\n"), 0644)
+	engine := distributed_engine.CreateEngine(logFile, "127.0.0.1:18050", nil, cache.Config{MaxEntries: 10}, false, "")
+	server := httptest.NewServer(engine.HttpHandler())
+	defer server.Close()
+
+	for _, query := range []string{`grep -c ERROR`, `grep --count-by level`, `rm -rf /`} {
+		resp, err := http.Get(server.URL + "/stream?q=" + url.QueryEscape(query))
+		if err != nil {
+			t.Fatalf("GET /stream failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected %s not to be streamed, but got status %d", query, resp.StatusCode)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/stream?interval=100ms&q="+url.QueryEscape("grep ERROR"), nil)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("GET /stream failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, but got %s", resp.Header.Get("Content-Type"))
+	}
+
+	// only the lines appended after the stream started are sent, including the ones of a batch too large for one poll
+	time.Sleep(300 * time.Millisecond)
+	appended := []string{"ERROR: Disk still full"}
+	for i := 0; i < distributed_engine.FOLLOW_BATCH_SIZE; i++ {
+		appended = append(appended, "ERROR: Retry failed")
+	}
+	file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = file.WriteString("INFO: Retrying\n" + strings.Join(appended, "\n") + "\n")
+	_ = file.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	expectMatches := func(expectedLines []string) {
+		for i, expected := range expectedLines {
+			eventLine, err1 := reader.ReadString('\n')
+			dataLine, err2 := reader.ReadString('\n')
+			_, _ = reader.ReadString('\n')
+			if err1 != nil || err2 != nil {
+				t.Fatalf("Failed to read event %d: %v %v", i, err1, err2)
+			}
+			var event distributed_engine.StreamEvent
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(dataLine, "data: ")), &event); err != nil {
+				t.Fatalf("Invalid event %q: %v", dataLine, err)
+			}
+			if eventLine != "event: match\n" || event.Line != expected || filepath.Base(event.Filename) != "vm1.log" || event.Machine == "" {
+				t.Fatalf("Expected event %d to be the match %q, but got %s%+v", i, expected, eventLine, event)
+			}
+		}
+	}
+	expectMatches(appended)
+
+	// the lines of a rotated log are all new, even though it has fewer lines than were already sent
+	_ = os.WriteFile(logFile, []byte("ERROR: Disk full after rotation\nINFO: Retrying\n"), 0644)
+	expectMatches([]string{"ERROR: Disk full after rotation"})
+}
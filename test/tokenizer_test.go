@@ -0,0 +1,76 @@
+package test
+
+import (
+	"cs425_mp1/internal/grep"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeShellInput(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{`grep -c ERROR`, []string{"grep", "-c", "ERROR"}},
+		{"grep \t -c   ERROR  ", []string{"grep", "-c", "ERROR"}},
+		{`grep 'api  request'`, []string{"grep", "api  request"}},
+		{`grep "api	request"`, []string{"grep", "api\trequest"}},
+		{`grep 'Configuration\|Application'`, []string{"grep", `Configuration\|Application`}},
+		{`grep "Configuration\|Application"`, []string{"grep", `Configuration\|Application`}},
+		{`grep "say \"hi\" to \\me"`, []string{"grep", `say "hi" to \me`}},
+		{`grep "File not found: 'file.txt'"`, []string{"grep", "File not found: 'file.txt'"}},
+		{`grep 'User '\''guest'\'''`, []string{"grep", "User 'guest'"}},
+		{`grep Cache\ size`, []string{"grep", "Cache size"}},
+		{`grep -e '' -e a'b c'"d"`, []string{"grep", "-e", "", "-e", "ab cd"}},
+		{`grep -E ^[eE]|^W`, []string{"grep", "-E", "^[eE]|^W"}},
+	}
+
+	for _, c := range cases {
+		words, err := grep.TokenizeShellInput(c.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.input, err)
+		} else if !reflect.DeepEqual(words, c.expected) {
+			t.Errorf("%s: expected %q, but got %q", c.input, c.expected, words)
+		}
+	}
+}
+
+func TestTokenizeShellInputErrors(t *testing.T) {
+	cases := map[string]string{
+		`grep 'unterminated`:      "Unterminated single quote opened at position 6",
+		`grep "unterminated`:      "Unterminated double quote opened at position 6",
+		`grep "escaped quote\"`:   "Unterminated double quote opened at position 6",
+		`grep ok 'a'"b' trailing`: "Unterminated double quote opened at position 12",
+		`grep trailing\`:          "Trailing backslash at position 14",
+	}
+
+	for input, expectedMsg := range cases {
+		_, err := grep.TokenizeShellInput(input)
+		if err == nil {
+			t.Errorf("%s: expected an error", input)
+		} else if !strings.Contains(err.Error(), expectedMsg) {
+			t.Errorf("%s: expected error containing %q, but got %q", input, expectedMsg, err.Error())
+		}
+	}
+}
+
+// Tests that quoting args and tokenizing them again gives back the same args
+func TestQuoteShellArgsRoundTrip(t *testing.T) {
+	argsList := [][]string{
+		{"grep", "-c", "ERROR"},
+		{"grep", "api  request", "tab\there", "new\nline"},
+		{"grep", "User 'guest'", `say "hi"`, `back\slash`, `\`, "'"},
+		{"grep", "-e", "", "-e", "$HOME", "a|b;c"},
+	}
+
+	for _, args := range argsList {
+		quoted := grep.QuoteShellArgs(args)
+		words, err := grep.TokenizeShellInput(quoted)
+		if err != nil {
+			t.Errorf("%q: unexpected error tokenizing %s: %v", args, quoted, err)
+		} else if !reflect.DeepEqual(words, args) {
+			t.Errorf("%q: quoted as %s, but tokenized back to %q", args, quoted, words)
+		}
+	}
+}